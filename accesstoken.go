@@ -24,22 +24,40 @@ func (this *AccessToken) Fresh() (string, error) {
 	if this.LckName == "" {
 		this.LckName = this.TmpName + ".lck"
 	}
+	return freshTmp(this.TmpName, this.LckName, this.fetch)
+}
+
+func (this *AccessToken) fetch() (string, error) {
+	rtn, err := get(fmt.Sprintf(
+		"%stoken?grant_type=client_credential&appid=%s&secret=%s",
+		UrlPrefix,
+		this.AppId,
+		this.AppSecret,
+	))
+	if err != nil {
+		return "", err
+	}
+	return rtn.AccessToken, nil
+}
+
+// get fresh value cached in tmp file, fetch and store it when expired
+func freshTmp(tmpName, lckName string, fetch func() (string, error)) (string, error) {
 	for {
-		if this.locked() {
+		if locked(lckName) {
 			time.Sleep(time.Second)
 			continue
 		}
 		break
 	}
-	fi, err := os.Stat(this.TmpName)
+	fi, err := os.Stat(tmpName)
 	if err != nil && !os.IsExist(err) {
-		return this.fetchAndStore()
+		return fetchAndStore(tmpName, lckName, fetch)
 	}
 	expires := fi.ModTime().Add(2 * time.Hour).Unix()
 	if expires <= time.Now().Unix() {
-		return this.fetchAndStore()
+		return fetchAndStore(tmpName, lckName, fetch)
 	}
-	tmp, err := os.Open(this.TmpName)
+	tmp, err := os.Open(tmpName)
 	if err != nil {
 		return "", err
 	}
@@ -51,72 +69,61 @@ func (this *AccessToken) Fresh() (string, error) {
 	return string(data), nil
 }
 
-func (this *AccessToken) fetchAndStore() (string, error) {
-	if err := this.lock(); err != nil {
+func fetchAndStore(tmpName, lckName string, fetch func() (string, error)) (string, error) {
+	if err := lock(lckName); err != nil {
 		return "", err
 	}
-	defer this.unlock()
-	token, err := this.fetch()
+	defer unlock(lckName)
+	value, err := fetch()
 	if err != nil {
 		return "", err
 	}
-	if err := this.store(token); err != nil {
+	if err := store(tmpName, value); err != nil {
 		return "", err
 	}
-	return token, nil
+	return value, nil
 }
 
-func (this *AccessToken) store(token string) error {
-	path := path.Dir(this.TmpName)
+func store(tmpName, value string) error {
+	path := path.Dir(tmpName)
 	fi, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		if err := os.MkdirAll(path, os.ModePerm); err != nil {
 			return err
 		}
-	}
-	if !fi.IsDir() {
+	} else if err != nil {
+		return err
+	} else if !fi.IsDir() {
 		return errors.New("path is not a directory")
 	}
-	tmp, err := os.OpenFile(this.TmpName, os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		return err
 	}
 	defer tmp.Close()
-	if _, err := tmp.Write([]byte(token)); err != nil {
+	if _, err := tmp.Write([]byte(value)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (this *AccessToken) fetch() (string, error) {
-	rtn, err := get(fmt.Sprintf(
-		"%stoken?grant_type=client_credential&appid=%s&secret=%s",
-		UrlPrefix,
-		this.AppId,
-		this.AppSecret,
-	))
-	if err != nil {
-		return "", err
-	}
-	return rtn.AccessToken, nil
-}
-
-func (this *AccessToken) unlock() error {
-	return os.Remove(this.LckName)
+func unlock(lckName string) error {
+	return os.Remove(lckName)
 }
 
-func (this *AccessToken) lock() error {
-	path := path.Dir(this.LckName)
+func lock(lckName string) error {
+	path := path.Dir(lckName)
 	fi, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		if err := os.MkdirAll(path, os.ModePerm); err != nil {
 			return err
 		}
-	}
-	if !fi.IsDir() {
+	} else if err != nil {
+		return err
+	} else if !fi.IsDir() {
 		return errors.New("path is not a directory")
 	}
-	lck, err := os.Create(this.LckName)
+	lck, err := os.Create(lckName)
 	if err != nil {
 		return err
 	}
@@ -124,7 +131,7 @@ func (this *AccessToken) lock() error {
 	return nil
 }
 
-func (this *AccessToken) locked() bool {
-	_, err := os.Stat(this.LckName)
+func locked(lckName string) bool {
+	_, err := os.Stat(lckName)
 	return !os.IsNotExist(err)
 }
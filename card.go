@@ -0,0 +1,139 @@
+package weixinmp
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wx_card api_ticket for card js-sdk, cached like access_token
+type CardTicket struct {
+	AccessToken *AccessToken
+	TmpName     string
+	LckName     string
+}
+
+// get fresh wx_card api_ticket string
+func (this *CardTicket) Fresh() (string, error) {
+	if this.AccessToken == nil {
+		return "", errors.New("CardTicket access token is not set")
+	}
+	if this.TmpName == "" {
+		this.TmpName = this.AccessToken.AppId + "-cardticket.tmp"
+	}
+	if this.LckName == "" {
+		this.LckName = this.TmpName + ".lck"
+	}
+	return freshTmp(this.TmpName, this.LckName, func() (string, error) {
+		return fetchTicket(this.AccessToken, "wx_card")
+	})
+}
+
+// wx_card api_ticket using access_token of mp, also when mp is not created by New
+func (this *Weixinmp) cardTicket() *CardTicket {
+	if this.CardTicket.AccessToken == nil {
+		this.CardTicket.AccessToken = &this.AccessToken
+	}
+	return &this.CardTicket
+}
+
+// cardExt of wx.addCard
+type CardExt struct {
+	Code                string `json:"code,omitempty"`
+	OpenId              string `json:"openid,omitempty"`
+	Timestamp           string `json:"timestamp"`
+	NonceStr            string `json:"nonce_str"`
+	FixedBeginTimestamp int64  `json:"fixed_begintimestamp,omitempty"`
+	OuterStr            string `json:"outer_str,omitempty"`
+	Signature           string `json:"signature"`
+}
+
+// sign cardExt for wx.addCard, code and openId are optional
+func (this *Weixinmp) SignCardExt(cardId string, ext *CardExt) error {
+	ticket, err := this.cardTicket().Fresh()
+	if err != nil {
+		return err
+	}
+	if ext.Timestamp == "" {
+		ext.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if ext.NonceStr == "" {
+		if ext.NonceStr, err = randomHex(8); err != nil {
+			return err
+		}
+	}
+	ext.Signature = cardSign(ticket, ext.Timestamp, cardId, ext.Code, ext.OpenId, ext.NonceStr)
+	return nil
+}
+
+// card item of wx.addCard
+type AddCardItem struct {
+	CardId  string `json:"cardId"`
+	CardExt string `json:"cardExt"`
+}
+
+// build signed card item for wx.addCard
+func (this *Weixinmp) AddCardItem(cardId, code, openId string) (*AddCardItem, error) {
+	ext := CardExt{Code: code, OpenId: openId}
+	if err := this.SignCardExt(cardId, &ext); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&ext)
+	if err != nil {
+		return nil, err
+	}
+	return &AddCardItem{CardId: cardId, CardExt: string(data)}, nil
+}
+
+// config of wx.chooseCard
+type ChooseCardConfig struct {
+	ShopId    string `json:"shopId"`
+	CardType  string `json:"cardType"`
+	CardId    string `json:"cardId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	SignType  string `json:"signType"`
+	CardSign  string `json:"cardSign"`
+}
+
+// sign config for wx.chooseCard, shopId, cardType and cardId are optional filters
+func (this *Weixinmp) SignChooseCard(shopId, cardType, cardId string) (*ChooseCardConfig, error) {
+	ticket, err := this.cardTicket().Fresh()
+	if err != nil {
+		return nil, err
+	}
+	nonceStr, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ChooseCardConfig{
+		ShopId:    shopId,
+		CardType:  cardType,
+		CardId:    cardId,
+		Timestamp: time.Now().Unix(),
+		NonceStr:  nonceStr,
+		SignType:  "SHA1",
+	}
+	cfg.CardSign = cardSign(
+		ticket,
+		this.AccessToken.AppId,
+		shopId,
+		strconv.FormatInt(cfg.Timestamp, 10),
+		nonceStr,
+		cardId,
+		cardType,
+	)
+	return cfg, nil
+}
+
+// sha1 of values sorted by dictionary
+func cardSign(values ...string) string {
+	ss := sort.StringSlice(values)
+	sort.Strings(ss)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(ss, ""))))
+}
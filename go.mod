@@ -0,0 +1,8 @@
+module github.com/sidbusy/weixinmp
+
+go 1.13
+
+require (
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,284 @@
+package weixinmp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+const (
+	// material types
+	MaterialTypeImage = "image"
+	MaterialTypeVoice = "voice"
+	MaterialTypeVideo = "video"
+	MaterialTypeThumb = "thumb"
+	MaterialTypeNews  = "news"
+	// items per batchget_material call, 20 at most
+	materialPageSize = 20
+)
+
+// article of news material
+type NewsArticle struct {
+	Title              string `json:"title"`
+	ThumbMediaId       string `json:"thumb_media_id"`
+	Author             string `json:"author"`
+	Digest             string `json:"digest"`
+	ShowCoverPic       int64  `json:"show_cover_pic"`
+	Content            string `json:"content"`
+	ContentSourceUrl   string `json:"content_source_url"`
+	NeedOpenComment    int64  `json:"need_open_comment,omitempty"`
+	OnlyFansCanComment int64  `json:"only_fans_can_comment,omitempty"`
+	Url                string `json:"url,omitempty"`
+	ThumbUrl           string `json:"thumb_url,omitempty"`
+}
+
+type VideoMaterial struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	DownUrl     string `json:"down_url"`
+}
+
+type MaterialCount struct {
+	VoiceCount int64 `json:"voice_count"`
+	VideoCount int64 `json:"video_count"`
+	ImageCount int64 `json:"image_count"`
+	NewsCount  int64 `json:"news_count"`
+}
+
+type MaterialItem struct {
+	MediaId string `json:"media_id"`
+	// news
+	Content struct {
+		NewsItem []NewsArticle `json:"news_item"`
+	} `json:"content"`
+	// others
+	Name       string `json:"name"`
+	Url        string `json:"url"`
+	UpdateTime int64  `json:"update_time"`
+}
+
+type MaterialList struct {
+	TotalCount int64          `json:"total_count"`
+	ItemCount  int64          `json:"item_count"`
+	Item       []MaterialItem `json:"item"`
+}
+
+// size and format limits of permanent material, wider than of temporary media
+var materialLimits = map[string]*mediaLimit{
+	MaterialTypeImage: {10 << 20, append([]mediaFormat{{".png", "image/png"}, {".gif", "image/gif"}, {".bmp", "image/bmp"}}, jpegFormats...)},
+	MaterialTypeVoice: {2 << 20, []mediaFormat{{".mp3", "audio/mpeg"}, {".amr", "audio/amr"}, {".wav", "audio/wav"}, {".wma", "audio/x-ms-wma"}}},
+	MaterialTypeVideo: {10 << 20, []mediaFormat{{".mp4", "video/mp4"}}},
+	MaterialTypeThumb: {64 << 10, jpegFormats},
+}
+
+// add permanent material of image, voice or thumb, returns media_id and url (image only)
+func (this *Weixinmp) AddMaterial(mediaType, fileName string) (string, string, error) {
+	if mediaType == MaterialTypeVideo {
+		return "", "", errors.New("use AddVideoMaterial for video")
+	}
+	url := fmt.Sprintf("%smaterial/add_material?type=%s&access_token=", UrlPrefix, mediaType)
+	rtn, err := this.uploadFile(url, mediaType, fileName, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return rtn.MediaId, rtn.Url, nil
+}
+
+// add permanent video material
+func (this *Weixinmp) AddVideoMaterial(fileName, title, introduction string) (string, error) {
+	var desc struct {
+		Title        string `json:"title"`
+		Introduction string `json:"introduction"`
+	}
+	desc.Title = title
+	desc.Introduction = introduction
+	data, err := json.Marshal(&desc)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%smaterial/add_material?type=%s&access_token=", UrlPrefix, MaterialTypeVideo)
+	rtn, err := this.uploadFile(url, MaterialTypeVideo, fileName, map[string]string{"description": string(data)})
+	if err != nil {
+		return "", err
+	}
+	return rtn.MediaId, nil
+}
+
+// upload image used in news content, returns the image url
+func (this *Weixinmp) UploadNewsImage(fileName string) (string, error) {
+	url := fmt.Sprintf("%smedia/uploadimg?access_token=", UrlPrefix)
+	rtn, err := this.upload(context.Background(), url, filepath.Base(fileName), "", newsImageLimit, fileOpener(fileName), nil)
+	if err != nil {
+		return "", err
+	}
+	return rtn.Url, nil
+}
+
+// add permanent news material
+func (this *Weixinmp) AddNews(articles []NewsArticle) (string, error) {
+	var req struct {
+		Articles []NewsArticle `json:"articles"`
+	}
+	req.Articles = articles
+	var rtn response
+	if err := this.postAPI("material/add_news", &req, &rtn); err != nil {
+		return "", err
+	}
+	return rtn.MediaId, nil
+}
+
+// update article at index of news material
+func (this *Weixinmp) UpdateNews(mediaId string, index int64, article *NewsArticle) error {
+	var req struct {
+		MediaId  string       `json:"media_id"`
+		Index    int64        `json:"index"`
+		Articles *NewsArticle `json:"articles"`
+	}
+	req.MediaId = mediaId
+	req.Index = index
+	req.Articles = article
+	return this.postAPI("material/update_news", &req, nil)
+}
+
+// get news material
+func (this *Weixinmp) GetNewsMaterial(mediaId string) ([]NewsArticle, error) {
+	var rtn struct {
+		NewsItem []NewsArticle `json:"news_item"`
+	}
+	if err := this.postAPI("material/get_material", &mediaIdReq{mediaId}, &rtn); err != nil {
+		return nil, err
+	}
+	return rtn.NewsItem, nil
+}
+
+// get video material
+func (this *Weixinmp) GetVideoMaterial(mediaId string) (*VideoMaterial, error) {
+	var rtn VideoMaterial
+	if err := this.postAPI("material/get_material", &mediaIdReq{mediaId}, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// download image, voice or thumb material to file
+func (this *Weixinmp) DownloadMaterial(mediaId, fileName string) error {
+	body, err := json.Marshal(&mediaIdReq{mediaId})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%smaterial/get_material?access_token=", UrlPrefix)
+	return writeFileAtomic(fileName, func(w io.Writer) error {
+		_, _, err := this.download(context.Background(), "POST", url, body, w)
+		return err
+	})
+}
+
+// delete permanent material
+func (this *Weixinmp) DeleteMaterial(mediaId string) error {
+	return this.postAPI("material/del_material", &mediaIdReq{mediaId}, nil)
+}
+
+// get count of permanent materials
+func (this *Weixinmp) GetMaterialCount() (*MaterialCount, error) {
+	var rtn MaterialCount
+	if err := this.getAPI("material/get_materialcount", nil, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// get at most 20 materials of type from offset
+func (this *Weixinmp) BatchGetMaterial(mediaType string, offset, count int64) (*MaterialList, error) {
+	return this.batchGetMaterial(context.Background(), mediaType, offset, count)
+}
+
+func (this *Weixinmp) batchGetMaterial(ctx context.Context, mediaType string, offset, count int64) (*MaterialList, error) {
+	var req struct {
+		Type   string `json:"type"`
+		Offset int64  `json:"offset"`
+		Count  int64  `json:"count"`
+	}
+	req.Type = mediaType
+	req.Offset = offset
+	req.Count = count
+	var rtn MaterialList
+	url := fmt.Sprintf("%smaterial/batchget_material?access_token=", UrlPrefix)
+	err := this.call(ctx, func(token string) error {
+		return postJSON(ctx, url+token, &req, &rtn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// iterate materials page by page
+type MaterialIterator struct {
+	mp        *Weixinmp
+	mediaType string
+	offset    int64
+	total     int64
+	items     []MaterialItem
+	done      bool
+	err       error
+}
+
+// list materials of type, starting at offset
+func (this *Weixinmp) ListMaterials(mediaType string, offset int64) *MaterialIterator {
+	return &MaterialIterator{mp: this, mediaType: mediaType, offset: offset}
+}
+
+// fetch next page, returns false when finished or failed
+func (this *MaterialIterator) Next(ctx context.Context) bool {
+	if this.done || this.err != nil {
+		return false
+	}
+	list, err := this.mp.batchGetMaterial(ctx, this.mediaType, this.offset, materialPageSize)
+	if err != nil {
+		this.err = err
+		return false
+	}
+	this.total = list.TotalCount
+	if len(list.Item) == 0 {
+		this.items = nil
+		this.done = true
+		return false
+	}
+	this.items = list.Item
+	this.offset += int64(len(list.Item))
+	if this.offset >= this.total {
+		this.done = true
+	}
+	return true
+}
+
+// materials of current page
+func (this *MaterialIterator) Items() []MaterialItem {
+	return this.items
+}
+
+// total number of materials of the type
+func (this *MaterialIterator) Total() int64 {
+	return this.total
+}
+
+// cursor for resuming after current page
+func (this *MaterialIterator) Offset() int64 {
+	return this.offset
+}
+
+// error stopped the iteration
+func (this *MaterialIterator) Err() error {
+	return this.err
+}
+
+type mediaIdReq struct {
+	MediaId string `json:"media_id"`
+}
+
+func (this *Weixinmp) uploadFile(url, mediaType, fileName string, fields map[string]string) (*response, error) {
+	return this.upload(context.Background(), url, filepath.Base(fileName), "", materialLimits[mediaType], fileOpener(fileName), fields)
+}
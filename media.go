@@ -0,0 +1,421 @@
+package weixinmp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// size and format limits of media
+type mediaLimit struct {
+	Size    int64
+	Formats []mediaFormat // the first format of a content type gives its extension
+}
+
+type mediaFormat struct {
+	Ext  string
+	Type string
+}
+
+var (
+	jpegFormats = []mediaFormat{{".jpg", "image/jpeg"}, {".jpeg", "image/jpeg"}}
+	mediaLimits = map[string]*mediaLimit{
+		MediaTypeImage: {10 << 20, append([]mediaFormat{{".png", "image/png"}, {".gif", "image/gif"}}, jpegFormats...)},
+		MediaTypeVoice: {2 << 20, []mediaFormat{{".amr", "audio/amr"}, {".mp3", "audio/mpeg"}}},
+		MediaTypeVideo: {10 << 20, []mediaFormat{{".mp4", "video/mp4"}}},
+		MediaTypeThumb: {64 << 10, jpegFormats},
+	}
+	// image in news content
+	newsImageLimit = &mediaLimit{1 << 20, append([]mediaFormat{{".png", "image/png"}}, jpegFormats...)}
+)
+
+// content type of extension
+func (this *mediaLimit) extType(ext string) (string, bool) {
+	for _, f := range this.Formats {
+		if f.Ext == ext {
+			return f.Type, true
+		}
+	}
+	return "", false
+}
+
+// extension of content type
+func (this *mediaLimit) typeExt(contentType string) (string, bool) {
+	for _, f := range this.Formats {
+		if f.Type == contentType {
+			return f.Ext, true
+		}
+	}
+	return "", false
+}
+
+// upload media from r, name is the file name.
+// contentType is sniffed from content if empty, and must agree with
+// the extension of name; the extension is appended if name has none.
+// r is rewound for retries if it is an io.Seeker, or not retried.
+func (this *Weixinmp) UploadMedia(ctx context.Context, mediaType, name, contentType string, r io.Reader) (string, error) {
+	rtn, err := this.uploadMedia(ctx, mediaType, name, contentType, rewinder(r))
+	if err != nil {
+		return "", err
+	}
+	return rtn.MediaId, nil
+}
+
+func (this *Weixinmp) uploadMedia(ctx context.Context, mediaType, name, contentType string, open opener) (*response, error) {
+	url := fmt.Sprintf("%supload?type=%s&access_token=", MediaUrlPrefix, mediaType)
+	return this.upload(ctx, url, name, contentType, mediaLimits[mediaType], open, nil)
+}
+
+// open content to upload, closer may be nil
+type opener func() (io.Reader, io.Closer, error)
+
+// opener of file, reopened for each retry
+func fileOpener(fileName string) opener {
+	return func() (io.Reader, io.Closer, error) {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	}
+}
+
+// opener of reader, rewound for each retry if possible
+func rewinder(r io.Reader) opener {
+	used := false
+	start := int64(-1)
+	seeker, ok := r.(io.Seeker)
+	if ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			start = offset
+		}
+	}
+	return func() (io.Reader, io.Closer, error) {
+		if !used {
+			used = true
+			return r, nil, nil
+		}
+		if start < 0 {
+			return nil, nil, errors.New("reader cannot be rewound")
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		return r, nil, nil
+	}
+}
+
+// upload content in multipart field "media" with extra fields
+func (this *Weixinmp) upload(ctx context.Context, url, name, contentType string, limit *mediaLimit, open opener, fields map[string]string) (*response, error) {
+	var rtn response
+	var last error
+	err := this.call(ctx, func(token string) error {
+		r, c, err := open()
+		if err != nil {
+			// report the failed upload rather than failed reopen
+			if last != nil {
+				return noRetry{last}
+			}
+			return noRetry{err}
+		}
+		if c != nil {
+			defer c.Close()
+		}
+		name, contentType, r, err := checkMedia(name, contentType, limit, r)
+		if err != nil {
+			return noRetry{err}
+		}
+		last = streamForm(ctx, url+token, name, contentType, r, fields, &rtn)
+		return last
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// check format and size of media, returns file name with extension,
+// content type and reader failing when size limit exceeded
+func checkMedia(name, contentType string, limit *mediaLimit, r io.Reader) (string, string, io.Reader, error) {
+	size, sized := readerSize(r)
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", "", nil, err
+	}
+	sniffed := sniffContentType(head)
+	if limit == nil {
+		if contentType == "" {
+			contentType = sniffed
+		}
+		return name, contentType, br, nil
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	extType, extOk := limit.extType(ext)
+	if contentType == "" {
+		if extOk {
+			contentType = extType
+		} else {
+			contentType = sniffed
+		}
+	}
+	if _, ok := limit.typeExt(contentType); !ok {
+		return "", "", nil, errors.New(fmt.Sprintf("unsupported media format %s %s", ext, contentType))
+	}
+	if extOk && extType != contentType {
+		return "", "", nil, errors.New(fmt.Sprintf("media extension %s does not match content type %s", ext, contentType))
+	}
+	// only formats recognized by sniffing are compared
+	if _, ok := limit.typeExt(sniffed); ok && sniffed != contentType {
+		return "", "", nil, errors.New(fmt.Sprintf("media content %s does not match content type %s", sniffed, contentType))
+	}
+	if !extOk {
+		e, _ := limit.typeExt(contentType)
+		name += e
+	}
+	if sized && size > limit.Size {
+		return "", "", nil, errors.New(fmt.Sprintf("media size %d exceeds %d", size, limit.Size))
+	}
+	return name, contentType, &limitedReader{br, limit.Size}, nil
+}
+
+func sniffContentType(head []byte) string {
+	if bytes.HasPrefix(head, []byte("#!AMR")) {
+		return "audio/amr"
+	}
+	contentType := http.DetectContentType(head)
+	if contentType == "audio/wave" {
+		return "audio/wav"
+	}
+	return contentType
+}
+
+// remaining size of reader, if known
+func readerSize(r io.Reader) (int64, bool) {
+	if l, ok := r.(interface {
+		Len() int
+	}); ok {
+		return int64(l.Len()), true
+	}
+	if s, ok := r.(io.Seeker); ok {
+		cur, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := s.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// reader fails when reading more than n bytes
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (this *limitedReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.n -= int64(n)
+	if this.n < 0 {
+		return n, errors.New("media size exceeds limit")
+	}
+	return n, err
+}
+
+// post multipart form streamed through a pipe
+func streamForm(ctx context.Context, url, name, contentType string, r io.Reader, fields map[string]string, v interface{}) error {
+	pr, pw := io.Pipe()
+	bw := multipart.NewWriter(pw)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeForm(bw, name, contentType, r, fields))
+	}()
+	// unblock and wait for writer, r is not read after return
+	defer func() {
+		pr.Close()
+		<-done
+	}()
+	req, err := http.NewRequest("POST", url, pr)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", bw.FormDataContentType())
+	return doJSON(ctx, req, v)
+}
+
+func writeForm(bw *multipart.Writer, name, contentType string, r io.Reader, fields map[string]string) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="media"; filename="%s"`, quoteEscaper.Replace(name)))
+	h.Set("Content-Type", contentType)
+	fw, err := bw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		if err := bw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	return bw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// download media to w, returns file name (empty if not given) and content type.
+// video media is fetched from its video_url.
+func (this *Weixinmp) DownloadMedia(ctx context.Context, mediaId string, w io.Writer) (string, string, error) {
+	url := fmt.Sprintf("%sget?media_id=%s&access_token=", MediaUrlPrefix, mediaId)
+	return this.download(ctx, "GET", url, nil, w)
+}
+
+// download to w with fresh access_token, retry until anything written
+func (this *Weixinmp) download(ctx context.Context, method, url string, body []byte, w io.Writer) (string, string, error) {
+	cw := &countWriter{w: w}
+	var name, contentType string
+	var last error
+	err := this.call(ctx, func(token string) error {
+		if cw.n > 0 {
+			return noRetry{last}
+		}
+		var err error
+		name, contentType, err = fetchMedia(ctx, method, url+token, body, cw)
+		last = err
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return name, contentType, nil
+}
+
+// media json response, error or video url
+type mediaJSON struct {
+	response
+	VideoUrl string `json:"video_url"`
+}
+
+// fetch media from url to w, returns file name and content type
+func fetchMedia(ctx context.Context, method, url string, body []byte, w io.Writer) (string, string, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		return "", "", err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.New(resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	br := bufio.NewReader(resp.Body)
+	var r io.Reader = br
+	// json?
+	if isJSON(mediaType, br) {
+		data, err := ioutil.ReadAll(io.LimitReader(br, 1<<20))
+		if err != nil {
+			return "", "", err
+		}
+		var rtn mediaJSON
+		if err := json.Unmarshal(data, &rtn); err == nil {
+			if rtn.ErrCode != 0 {
+				return "", "", &Error{rtn.ErrCode, rtn.ErrMsg}
+			}
+			if rtn.VideoUrl != "" {
+				return fetchMedia(ctx, "GET", rtn.VideoUrl, nil, w)
+			}
+			return "", "", errors.New("unexpected json response: " + string(data))
+		}
+		// not json after all
+		r = io.MultiReader(bytes.NewReader(data), br)
+	}
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return "", "", err
+	}
+	return name, contentType, nil
+}
+
+// body is json, by content type or by content for other than media types
+func isJSON(mediaType string, br *bufio.Reader) bool {
+	switch {
+	case mediaType == "application/json", mediaType == "text/plain":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	head, _ := br.Peek(64)
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return len(head) > 0 && head[0] == '{'
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (this *countWriter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.n += int64(n)
+	return n, err
+}
+
+// write file through a temp file in the same directory, renamed when done
+func writeFileAtomic(fileName string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = write(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package weixinmp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// temporary media expires 3 days after uploaded
+	mediaExpiry = 3 * 24 * time.Hour
+	// lock of FileMediaStore older than this is stale
+	mediaLockStale = 10 * time.Minute
+	// interval of checking lock of FileMediaStore
+	mediaLockWait = 100 * time.Millisecond
+)
+
+// media_id of uploaded media
+type MediaEntry struct {
+	MediaId   string `json:"media_id"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// storage of MediaCache, shared by processes to deduplicate uploads
+type MediaStore interface {
+	// get entry of key, nil if not found
+	Get(key string) (*MediaEntry, error)
+	Set(key string, entry *MediaEntry) error
+}
+
+// optionally implemented by MediaStore, to prevent concurrent uploads of the same key
+type MediaLocker interface {
+	Lock(key string) (unlock func(), err error)
+}
+
+// cache of temporary media ids keyed by content hash,
+// uploaded again when the id is about to expire
+type MediaCache struct {
+	Weixinmp *Weixinmp
+	Store    MediaStore
+	Margin   time.Duration // upload again this long before expiry, 1 hour if zero
+}
+
+func NewMediaCache(mp *Weixinmp, store MediaStore) *MediaCache {
+	return &MediaCache{Weixinmp: mp, Store: store}
+}
+
+// upload media from r unless uploaded before and not about to expire
+func (this *MediaCache) Upload(ctx context.Context, mediaType, name string, r io.ReadSeeker) (string, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	key := mediaType + "-" + hex.EncodeToString(h.Sum(nil))
+	if locker, ok := this.Store.(MediaLocker); ok {
+		unlock, err := locker.Lock(key)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+	}
+	entry, err := this.Store.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if entry != nil && this.fresh(entry) {
+		return entry.MediaId, nil
+	}
+	rtn, err := this.Weixinmp.uploadMedia(ctx, mediaType, name, "", rewinder(r))
+	if err != nil {
+		return "", err
+	}
+	entry = &MediaEntry{MediaId: rtn.MediaId, CreatedAt: rtn.CreatedAt}
+	if entry.CreatedAt == 0 {
+		entry.CreatedAt = time.Now().Unix()
+	}
+	if err := this.Store.Set(key, entry); err != nil {
+		return "", err
+	}
+	return entry.MediaId, nil
+}
+
+// upload media from file unless uploaded before and not about to expire
+func (this *MediaCache) UploadFile(mediaType, fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return this.Upload(context.Background(), mediaType, filepath.Base(fileName), f)
+}
+
+func (this *MediaCache) fresh(entry *MediaEntry) bool {
+	margin := this.Margin
+	if margin == 0 {
+		margin = time.Hour
+	}
+	expires := time.Unix(entry.CreatedAt, 0).Add(mediaExpiry - margin)
+	return time.Now().Before(expires)
+}
+
+// store entries as json files in Dir, locked by exclusively created lock files
+type FileMediaStore struct {
+	Dir string
+}
+
+func (this *FileMediaStore) Get(key string) (*MediaEntry, error) {
+	data, err := ioutil.ReadFile(this.name(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry MediaEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (this *FileMediaStore) Set(key string, entry *MediaEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(this.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(this.name(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// lock file is created exclusively, and taken over when older than
+// mediaLockStale as left by a crashed process
+func (this *FileMediaStore) Lock(key string) (func(), error) {
+	if err := os.MkdirAll(this.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lckName := this.name(key) + ".lck"
+	for {
+		f, err := os.OpenFile(lckName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lckName) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lckName); err == nil && time.Since(fi.ModTime()) > mediaLockStale {
+			os.Remove(lckName)
+			continue
+		}
+		time.Sleep(mediaLockWait)
+	}
+}
+
+func (this *FileMediaStore) name(key string) string {
+	return filepath.Join(this.Dir, key+".json")
+}
+
+// store entries in memory of current process
+type MemoryMediaStore struct {
+	mu      sync.Mutex
+	entries map[string]MediaEntry
+	busy    map[string]chan struct{}
+}
+
+func (this *MemoryMediaStore) Get(key string) (*MediaEntry, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	entry, ok := this.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (this *MemoryMediaStore) Set(key string, entry *MediaEntry) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.entries == nil {
+		this.entries = make(map[string]MediaEntry)
+	}
+	this.entries[key] = *entry
+	return nil
+}
+
+func (this *MemoryMediaStore) Lock(key string) (func(), error) {
+	this.mu.Lock()
+	for {
+		ch, ok := this.busy[key]
+		if !ok {
+			break
+		}
+		this.mu.Unlock()
+		<-ch
+		this.mu.Lock()
+	}
+	if this.busy == nil {
+		this.busy = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	this.busy[key] = ch
+	this.mu.Unlock()
+	return func() {
+		this.mu.Lock()
+		delete(this.busy, key)
+		this.mu.Unlock()
+		close(ch)
+	}, nil
+}
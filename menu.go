@@ -0,0 +1,257 @@
+package weixinmp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// match rule sex
+	MatchSexMale   = "1"
+	MatchSexFemale = "2"
+	// match rule client platform types
+	MatchPlatformIOS     = "1"
+	MatchPlatformAndroid = "2"
+	MatchPlatformOthers  = "3"
+)
+
+// limits of custom menu
+const (
+	menuMaxButtons    = 3
+	menuMaxSubButtons = 5
+	menuMaxNameLen    = 16 // bytes of top-level button name
+	menuMaxSubNameLen = 60 // bytes of sub button name
+	menuMaxKeyLen     = 128
+	menuMaxUrlLen     = 1024
+)
+
+// rule matching users of conditional menu, empty fields match all
+type MatchRule struct {
+	TagId              RuleValue `json:"tag_id,omitempty"`
+	GroupId            RuleValue `json:"group_id,omitempty"` // returned by menu/get for old rules
+	Sex                RuleValue `json:"sex,omitempty"`
+	Country            RuleValue `json:"country,omitempty"`
+	Province           RuleValue `json:"province,omitempty"`
+	City               RuleValue `json:"city,omitempty"`
+	ClientPlatformType RuleValue `json:"client_platform_type,omitempty"`
+	Language           RuleValue `json:"language,omitempty"`
+}
+
+// field of match rule, decoded from json string or number
+type RuleValue string
+
+func (this *RuleValue) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*this = RuleValue(s)
+		return nil
+	}
+	if string(data) == "null" {
+		*this = ""
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*this = RuleValue(n.String())
+	return nil
+}
+
+// conditional menu (个性化菜单)
+type ConditionalMenu struct {
+	Button    []Button    `json:"button"`
+	MatchRule MatchRule   `json:"matchrule"`
+	MenuId    json.Number `json:"menuid,omitempty"`
+}
+
+// create conditional menu, returns menuid
+func (this *Weixinmp) AddConditionalMenu(btns []Button, rule *MatchRule) (string, error) {
+	if err := ValidateMenu(btns); err != nil {
+		return "", err
+	}
+	if rule == nil || *rule == (MatchRule{}) {
+		return "", errors.New("match rule is empty")
+	}
+	var req struct {
+		Button    []Button   `json:"button"`
+		MatchRule *MatchRule `json:"matchrule"`
+	}
+	req.Button = btns
+	req.MatchRule = rule
+	var rtn struct {
+		MenuId json.Number `json:"menuid"`
+	}
+	if err := this.postAPI("menu/addconditional", &req, &rtn); err != nil {
+		return "", err
+	}
+	return rtn.MenuId.String(), nil
+}
+
+// delete conditional menu
+func (this *Weixinmp) DeleteConditionalMenu(menuId string) error {
+	var req struct {
+		MenuId string `json:"menuid"`
+	}
+	req.MenuId = menuId
+	return this.postAPI("menu/delconditional", &req, nil)
+}
+
+// get menu matched by user, userId is openid or weixin id
+func (this *Weixinmp) TryMatchMenu(userId string) ([]Button, error) {
+	var req struct {
+		UserId string `json:"user_id"`
+	}
+	req.UserId = userId
+	var rtn struct {
+		Button []Button `json:"button"`
+	}
+	if err := this.postAPI("menu/trymatch", &req, &rtn); err != nil {
+		return nil, err
+	}
+	return rtn.Button, nil
+}
+
+// button types only used by menus set in the mp web console
+const (
+	SelfMenuTypeText  = "text"  // Value is the text
+	SelfMenuTypeImg   = "img"   // Value is media_id
+	SelfMenuTypeVoice = "voice" // Value is media_id
+	SelfMenuTypeVideo = "video" // Value is video url
+	SelfMenuTypeNews  = "news"  // Value is media_id, articles in NewsInfo
+)
+
+// current menu, set by api or in mp web console
+type SelfMenuInfo struct {
+	IsMenuOpen   int64 `json:"is_menu_open"`
+	SelfMenuInfo struct {
+		Button []SelfMenuButton `json:"button"`
+	} `json:"selfmenu_info"`
+}
+
+type SelfMenuButton struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Url      string `json:"url"`
+	Value    string `json:"value"`
+	NewsInfo struct {
+		List []SelfMenuNews `json:"list"`
+	} `json:"news_info"`
+	SubButton struct {
+		List []SelfMenuButton `json:"list"`
+	} `json:"sub_button"`
+}
+
+// article of news button
+type SelfMenuNews struct {
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Digest     string `json:"digest"`
+	ShowCover  int64  `json:"show_cover"`
+	CoverUrl   string `json:"cover_url"`
+	ContentUrl string `json:"content_url"`
+	SourceUrl  string `json:"source_url"`
+}
+
+// get current menu, including menus set in mp web console
+func (this *Weixinmp) GetSelfMenuInfo() (*SelfMenuInfo, error) {
+	var rtn SelfMenuInfo
+	if err := this.getAPI("get_current_selfmenu_info", nil, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// check buttons against the limits of custom menu
+func ValidateMenu(btns []Button) error {
+	if len(btns) == 0 {
+		return errors.New("menu has no button")
+	}
+	if len(btns) > menuMaxButtons {
+		return errors.New(fmt.Sprintf("menu has %d buttons, at most %d", len(btns), menuMaxButtons))
+	}
+	for _, btn := range btns {
+		if len(btn.Name) > menuMaxNameLen {
+			return errors.New(fmt.Sprintf("button %q: name longer than %d bytes", btn.Name, menuMaxNameLen))
+		}
+		if len(btn.SubButton) == 0 {
+			if err := validateButton(&btn); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(btn.SubButton) > menuMaxSubButtons {
+			return errors.New(fmt.Sprintf("button %q: has %d sub buttons, at most %d", btn.Name, len(btn.SubButton), menuMaxSubButtons))
+		}
+		for _, sub := range btn.SubButton {
+			if len(sub.Name) > menuMaxSubNameLen {
+				return errors.New(fmt.Sprintf("button %q: name longer than %d bytes", sub.Name, menuMaxSubNameLen))
+			}
+			if len(sub.SubButton) > 0 {
+				return errors.New(fmt.Sprintf("button %q: sub button can not have sub buttons", sub.Name))
+			}
+			if err := validateButton(&sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check fields required by button type
+func validateButton(btn *Button) error {
+	if btn.Name == "" {
+		return errors.New("button has no name")
+	}
+	if len(btn.Key) > menuMaxKeyLen {
+		return errors.New(fmt.Sprintf("button %q: key longer than %d bytes", btn.Name, menuMaxKeyLen))
+	}
+	if len(btn.Url) > menuMaxUrlLen {
+		return errors.New(fmt.Sprintf("button %q: url longer than %d bytes", btn.Name, menuMaxUrlLen))
+	}
+	missing := ""
+	switch btn.Type {
+	case ButtonTypeClick,
+		ButtonTypeScancodePush,
+		ButtonTypeScancodeWaitmsg,
+		ButtonTypePicSysphoto,
+		ButtonTypePicPhotoOrAlbum,
+		ButtonTypePicWeixin,
+		ButtonTypeLocationSelect:
+		if btn.Key == "" {
+			missing = "key"
+		}
+	case ButtonTypeView:
+		if btn.Url == "" {
+			missing = "url"
+		}
+	case ButtonTypeMediaId, ButtonTypeViewLimited:
+		if btn.MediaId == "" {
+			missing = "media_id"
+		}
+	case ButtonTypeArticleId, ButtonTypeArticleViewLimited:
+		if btn.ArticleId == "" {
+			missing = "article_id"
+		}
+	case ButtonTypeMiniprogram:
+		switch {
+		case btn.Url == "":
+			missing = "url"
+		case btn.AppId == "":
+			missing = "appid"
+		case btn.PagePath == "":
+			missing = "pagepath"
+		}
+	default:
+		return errors.New(fmt.Sprintf("button %q: unknown type %q", btn.Name, btn.Type))
+	}
+	if missing != "" {
+		return errors.New(fmt.Sprintf("button %q: %s is required by type %s", btn.Name, missing, btn.Type))
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package menu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sidbusy/weixinmp"
+)
+
+// change operations
+const (
+	OpAdd    = "+"
+	OpRemove = "-"
+	OpChange = "~"
+)
+
+// a change from live menu to desired menu
+type Change struct {
+	Op   string
+	Path string // e.g. button[0].sub_button[1]
+	Desc string
+}
+
+func (this Change) String() string {
+	if this.Desc == "" {
+		return fmt.Sprintf("%s %s", this.Op, this.Path)
+	}
+	return fmt.Sprintf("%s %s: %s", this.Op, this.Path, this.Desc)
+}
+
+// changes needed to turn live menu into desired menu
+type Plan struct {
+	Changes []Change
+	desired *Menu
+	// default menu changed
+	buttons bool
+	// conditional menus to delete and add
+	remove []weixinmp.ConditionalMenu
+	add    []weixinmp.ConditionalMenu
+}
+
+// compare live menu with desired menu
+func Diff(live, desired *Menu) *Plan {
+	plan := &Plan{desired: desired}
+	plan.Changes = diffButtons("button", live.Button, desired.Button)
+	plan.buttons = len(plan.Changes) > 0
+	// conditional menus are matched by rule
+	matched := make([]bool, len(live.ConditionalMenu))
+	for _, d := range desired.ConditionalMenu {
+		path := fmt.Sprintf("conditionalmenu[%s]", ruleString(&d.MatchRule))
+		found := false
+		for i, l := range live.ConditionalMenu {
+			if matched[i] || l.MatchRule != d.MatchRule {
+				continue
+			}
+			matched[i], found = true, true
+			if changes := diffButtons(path+".button", l.Button, d.Button); len(changes) > 0 {
+				plan.Changes = append(plan.Changes, changes...)
+				plan.remove = append(plan.remove, l)
+				plan.add = append(plan.add, d)
+			}
+			break
+		}
+		if !found {
+			plan.Changes = append(plan.Changes, Change{Op: OpAdd, Path: path})
+			plan.add = append(plan.add, d)
+		}
+	}
+	for i, l := range live.ConditionalMenu {
+		if !matched[i] {
+			plan.Changes = append(plan.Changes, Change{
+				Op:   OpRemove,
+				Path: fmt.Sprintf("conditionalmenu[%s]", ruleString(&l.MatchRule)),
+				Desc: "menuid " + l.MenuId.String(),
+			})
+			plan.remove = append(plan.remove, l)
+		}
+	}
+	return plan
+}
+
+// nothing to change
+func (this *Plan) Empty() bool {
+	return len(this.Changes) == 0
+}
+
+func (this *Plan) String() string {
+	if this.Empty() {
+		return "menu is up to date\n"
+	}
+	var buf bytes.Buffer
+	for _, c := range this.Changes {
+		buf.WriteString(c.String())
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// apply changes to live menu
+func (this *Plan) Apply(mp *weixinmp.Weixinmp) error {
+	if this.Empty() {
+		return nil
+	}
+	// deleting default menu deletes conditional menus too
+	if len(this.desired.Button) == 0 {
+		return mp.DeleteCustomMenu()
+	}
+	if this.buttons {
+		btns := this.desired.Button
+		if err := mp.CreateCustomMenu(&btns); err != nil {
+			return err
+		}
+	}
+	for _, cond := range this.remove {
+		if err := mp.DeleteConditionalMenu(cond.MenuId.String()); err != nil {
+			return err
+		}
+	}
+	for _, cond := range this.add {
+		rule := cond.MatchRule
+		if _, err := mp.AddConditionalMenu(cond.Button, &rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compare desired menu with live menu, print the plan to out,
+// and apply it if anything changed
+func Sync(mp *weixinmp.Weixinmp, desired *Menu, out io.Writer) (bool, error) {
+	btns, conds, err := mp.GetCustomMenu()
+	if err != nil && weixinmp.ErrCode(err) != weixinmp.ErrCodeMenuNotExist {
+		return false, err
+	}
+	plan := Diff(&Menu{Button: btns, ConditionalMenu: conds}, desired)
+	if out != nil {
+		if _, err := io.WriteString(out, plan.String()); err != nil {
+			return false, err
+		}
+	}
+	if plan.Empty() {
+		return false, nil
+	}
+	if err := plan.Apply(mp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func diffButtons(path string, live, desired []weixinmp.Button) []Change {
+	var changes []Change
+	for i := 0; i < len(live) || i < len(desired); i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(live):
+			changes = append(changes, Change{Op: OpAdd, Path: p, Desc: buttonString(&desired[i])})
+		case i >= len(desired):
+			changes = append(changes, Change{Op: OpRemove, Path: p, Desc: buttonString(&live[i])})
+		default:
+			l, d := live[i], desired[i]
+			l.SubButton, d.SubButton = nil, nil
+			if ls, ds := buttonString(&l), buttonString(&d); ls != ds {
+				changes = append(changes, Change{Op: OpChange, Path: p, Desc: ls + " -> " + ds})
+			}
+			changes = append(changes, diffButtons(p+".sub_button", live[i].SubButton, desired[i].SubButton)...)
+		}
+	}
+	return changes
+}
+
+func buttonString(btn *weixinmp.Button) string {
+	fields := []string{fmt.Sprintf("name=%q", btn.Name)}
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, fmt.Sprintf("%s=%q", k, v))
+		}
+	}
+	add("type", btn.Type)
+	add("key", btn.Key)
+	add("url", btn.Url)
+	add("media_id", btn.MediaId)
+	add("appid", btn.AppId)
+	add("pagepath", btn.PagePath)
+	add("article_id", btn.ArticleId)
+	if len(btn.SubButton) > 0 {
+		fields = append(fields, fmt.Sprintf("sub_button=%d", len(btn.SubButton)))
+	}
+	return strings.Join(fields, " ")
+}
+
+func ruleString(rule *weixinmp.MatchRule) string {
+	var fields []string
+	add := func(k string, v weixinmp.RuleValue) {
+		if v != "" {
+			fields = append(fields, k+"="+string(v))
+		}
+	}
+	add("tag_id", rule.TagId)
+	add("group_id", rule.GroupId)
+	add("sex", rule.Sex)
+	add("country", rule.Country)
+	add("province", rule.Province)
+	add("city", rule.City)
+	add("client_platform_type", rule.ClientPlatformType)
+	add("language", rule.Language)
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,207 @@
+// Package menu manages custom menus as code: menus are loaded from
+// YAML or JSON files, compared with the live menu and applied when changed.
+package menu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/sidbusy/weixinmp"
+	"gopkg.in/yaml.v2"
+)
+
+// menu definition, field names follow the menu/create api
+type Menu struct {
+	Button          []weixinmp.Button          `json:"button"`
+	ConditionalMenu []weixinmp.ConditionalMenu `json:"conditionalmenu,omitempty"`
+	handlers        map[string]weixinmp.HandlerFunc
+}
+
+// load menu from file, yaml if the extension is .yaml or .yml, json otherwise
+func Load(fileName string) (*Menu, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	}
+	return ParseJSON(data)
+}
+
+// parse menu from yaml
+func ParseYAML(data []byte) (*Menu, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return parseValue(v)
+}
+
+// parse menu from json
+func ParseJSON(data []byte) (*Menu, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return parseValue(v)
+}
+
+// parse menu from decoded yaml or json, strict about field names
+func parseValue(v interface{}) (*Menu, error) {
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var menu Menu
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&menu); err != nil {
+		return nil, err
+	}
+	if err := menu.Validate(); err != nil {
+		return nil, err
+	}
+	return &menu, nil
+}
+
+// check default and conditional menus against the limits of custom menu
+func (this *Menu) Validate() error {
+	if len(this.Button) == 0 {
+		if len(this.ConditionalMenu) > 0 {
+			return errors.New("conditional menu requires default menu")
+		}
+		return nil
+	}
+	if err := weixinmp.ValidateMenu(this.Button); err != nil {
+		return err
+	}
+	for _, cond := range this.ConditionalMenu {
+		if err := weixinmp.ValidateMenu(cond.Button); err != nil {
+			return err
+		}
+		if cond.MatchRule == (weixinmp.MatchRule{}) {
+			return errors.New("conditional menu has empty match rule")
+		}
+	}
+	return nil
+}
+
+// keys of buttons declared in menu
+func (this *Menu) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(btns []weixinmp.Button) {
+		for _, btn := range btns {
+			for _, b := range append([]weixinmp.Button{btn}, btn.SubButton...) {
+				if b.Key != "" && !seen[b.Key] {
+					seen[b.Key] = true
+					keys = append(keys, b.Key)
+				}
+			}
+		}
+	}
+	add(this.Button)
+	for _, cond := range this.ConditionalMenu {
+		add(cond.Button)
+	}
+	return keys
+}
+
+// bind handler to key of button declared in menu
+func (this *Menu) Handle(key string, h weixinmp.HandlerFunc) error {
+	declared := false
+	for _, k := range this.Keys() {
+		if k == key {
+			declared = true
+			break
+		}
+	}
+	if !declared {
+		return errors.New(fmt.Sprintf("menu key %q is not declared", key))
+	}
+	if this.handlers == nil {
+		this.handlers = make(map[string]weixinmp.HandlerFunc)
+	}
+	this.handlers[key] = h
+	return nil
+}
+
+// call handler bound to the key of menu event, returns false if none
+func (this *Menu) Dispatch(rw http.ResponseWriter, mp *weixinmp.Weixinmp) bool {
+	if mp.Request.MsgType != weixinmp.MsgTypeEvent {
+		return false
+	}
+	switch mp.Request.Event {
+	case weixinmp.EventClick,
+		weixinmp.EventScancodePush,
+		weixinmp.EventScancodeWaitmsg,
+		weixinmp.EventPicSysphoto,
+		weixinmp.EventPicPhotoOrAlbum,
+		weixinmp.EventPicWeixin,
+		weixinmp.EventLocationSelect:
+	default:
+		return false
+	}
+	h, ok := this.handlers[mp.Request.EventKey]
+	if !ok {
+		return false
+	}
+	h(rw, mp)
+	return true
+}
+
+// convert yaml maps to json objects, and scalars to strings
+// as every field of menu is a string
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int, int64, uint64, float64, bool, json.Number:
+		return fmt.Sprint(v), nil
+	case map[string]interface{}:
+		for k, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("menu key %v is not a string", k))
+			}
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[ks] = e
+		}
+		return m, nil
+	case []interface{}:
+		for i, e := range v {
+			e, err := jsonValue(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,265 @@
+package weixinmp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// oauth scopes
+	ScopeBase     = "snsapi_base"     // 静默授权, 仅获取openid
+	ScopeUserInfo = "snsapi_userinfo" // 弹出授权页面, 可获取用户信息
+	// environment constants
+	OAuthUrl     = "https://open.weixin.qq.com/connect/oauth2/authorize"
+	SnsUrlPrefix = "https://api.weixin.qq.com/sns/"
+	// cookies used by oauth middleware
+	oauthStateCookie  = "weixinmp_oauth_state"
+	oauthOpenIdCookie = "weixinmp_openid"
+	oauthCookieAge    = 30 * 24 * time.Hour
+)
+
+// web oauth2 (网页授权)
+type OAuth struct {
+	AppId     string
+	AppSecret string
+	// trust X-Forwarded-Proto and X-Forwarded-Host set by a tls terminating proxy,
+	// only enable it when requests can not reach the server but through the proxy
+	TrustProxy bool
+}
+
+// user access_token from web oauth2
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	OpenId       string `json:"openid"`
+	Scope        string `json:"scope"`
+	UnionId      string `json:"unionid"`
+}
+
+// user info from sns/userinfo
+type SnsUserInfo struct {
+	Openid     string   `json:"openid"`
+	Nickname   string   `json:"nickname"`
+	Sex        int64    `json:"sex"`
+	Province   string   `json:"province"`
+	City       string   `json:"city"`
+	Country    string   `json:"country"`
+	Headimgurl string   `json:"headimgurl"`
+	Privilege  []string `json:"privilege"`
+	UnionId    string   `json:"unionid"`
+}
+
+// get authorize url, redirect user to it to start oauth
+func (this *OAuth) AuthorizeURL(redirectURI, scope, state string) string {
+	// appid must be the first parameter
+	return fmt.Sprintf(
+		"%s?appid=%s&redirect_uri=%s&response_type=code&scope=%s&state=%s#wechat_redirect",
+		OAuthUrl,
+		url.QueryEscape(this.AppId),
+		url.QueryEscape(redirectURI),
+		url.QueryEscape(scope),
+		url.QueryEscape(state),
+	)
+}
+
+// exchange code for user access_token
+func (this *OAuth) Exchange(code string) (*OAuthToken, error) {
+	var tk OAuthToken
+	err := getJSON(context.Background(), fmt.Sprintf(
+		"%soauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code",
+		SnsUrlPrefix,
+		this.AppId,
+		this.AppSecret,
+		url.QueryEscape(code),
+	), &tk)
+	if err != nil {
+		return nil, err
+	}
+	return &tk, nil
+}
+
+// refresh user access_token
+func (this *OAuth) Refresh(refreshToken string) (*OAuthToken, error) {
+	var tk OAuthToken
+	err := getJSON(context.Background(), fmt.Sprintf(
+		"%soauth2/refresh_token?appid=%s&grant_type=refresh_token&refresh_token=%s",
+		SnsUrlPrefix,
+		this.AppId,
+		url.QueryEscape(refreshToken),
+	), &tk)
+	if err != nil {
+		return nil, err
+	}
+	return &tk, nil
+}
+
+// validate user access_token
+func (this *OAuth) Validate(accessToken, openId string) error {
+	return getJSON(context.Background(), fmt.Sprintf(
+		"%sauth?access_token=%s&openid=%s",
+		SnsUrlPrefix,
+		url.QueryEscape(accessToken),
+		url.QueryEscape(openId),
+	), nil)
+}
+
+// get user info, requires snsapi_userinfo scope, zh_CN if lang is empty
+func (this *OAuth) GetUserInfo(accessToken, openId string, lang ...Lang) (SnsUserInfo, error) {
+	var uinf SnsUserInfo
+	err := getJSON(context.Background(), fmt.Sprintf(
+		"%suserinfo?access_token=%s&openid=%s&lang=%s",
+		SnsUrlPrefix,
+		url.QueryEscape(accessToken),
+		url.QueryEscape(openId),
+		firstLang(lang...),
+	), &uinf)
+	return uinf, err
+}
+
+// get user info by OAuth.GetUserInfo, with the default language of mp if lang is empty
+func (this *Weixinmp) GetSnsUserInfo(accessToken, openId string, lang ...Lang) (SnsUserInfo, error) {
+	return this.OAuth.GetUserInfo(accessToken, openId, this.lang(lang...))
+}
+
+type oauthContextKey struct{}
+
+// openid put into request context by OAuth.Middleware
+func OpenIdFromContext(ctx context.Context) string {
+	openId, _ := ctx.Value(oauthContextKey{}).(string)
+	return openId
+}
+
+// middleware makes sure the user is authorized before calling next,
+// the openid is available by OpenIdFromContext
+func (this *OAuth) Middleware(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// authorized before
+		if c, err := req.Cookie(oauthOpenIdCookie); err == nil {
+			if openId, ok := this.verifyOpenId(c.Value); ok {
+				next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), oauthContextKey{}, openId)))
+				return
+			}
+		}
+		query := req.URL.Query()
+		code, state := query.Get("code"), query.Get("state")
+		// back from authorize page
+		if code != "" && state != "" {
+			c, err := req.Cookie(oauthStateCookie)
+			if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+				http.Error(rw, "invalid oauth state", http.StatusForbidden)
+				return
+			}
+			http.SetCookie(rw, &http.Cookie{Name: oauthStateCookie, Value: "", Path: "/", MaxAge: -1})
+			tk, err := this.Exchange(code)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadGateway)
+				return
+			}
+			http.SetCookie(rw, &http.Cookie{
+				Name:     oauthOpenIdCookie,
+				Value:    this.signOpenId(tk.OpenId, time.Now().Add(oauthCookieAge)),
+				Path:     "/",
+				MaxAge:   int(oauthCookieAge / time.Second),
+				HttpOnly: true,
+				Secure:   this.scheme(req) == "https",
+			})
+			// drop code and state from url
+			query.Del("code")
+			query.Del("state")
+			u := *req.URL
+			u.RawQuery = query.Encode()
+			http.Redirect(rw, req, u.RequestURI(), http.StatusFound)
+			return
+		}
+		// go to authorize page
+		state, err := randomHex(16)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(rw, &http.Cookie{
+			Name:     oauthStateCookie,
+			Value:    state,
+			Path:     "/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   this.scheme(req) == "https",
+		})
+		http.Redirect(rw, req, this.AuthorizeURL(this.requestURL(req), scope, state), http.StatusFound)
+	})
+}
+
+// cookie value: openid|expires|hmac
+func (this *OAuth) signOpenId(openId string, expires time.Time) string {
+	v := openId + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return v + "|" + this.mac(v)
+}
+
+func (this *OAuth) verifyOpenId(value string) (string, bool) {
+	i := strings.LastIndex(value, "|")
+	if i < 0 {
+		return "", false
+	}
+	v, sig := value[:i], value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(this.mac(v))) {
+		return "", false
+	}
+	parts := strings.SplitN(v, "|", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func (this *OAuth) mac(v string) string {
+	h := hmac.New(sha256.New, []byte(this.AppSecret))
+	h.Write([]byte(v))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// scheme of request as seen by the user
+func (this *OAuth) scheme(req *http.Request) string {
+	if this.TrustProxy {
+		if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// absolute url of request as seen by the user
+func (this *OAuth) requestURL(req *http.Request) string {
+	host := req.Host
+	if this.TrustProxy {
+		if h := req.Header.Get("X-Forwarded-Host"); h != "" {
+			host = h
+		}
+	}
+	return this.scheme(req) + "://" + host + req.URL.RequestURI()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
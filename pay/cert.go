@@ -0,0 +1,248 @@
+package pay
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refetch platform certificates this often
+	certRefresh = 12 * time.Hour
+	// reject signed responses and notifications older than this
+	signatureMaxAge = 5 * time.Minute
+)
+
+// headers of signed responses and notifications
+const (
+	headerSerial    = "Wechatpay-Serial"
+	headerSignature = "Wechatpay-Signature"
+	headerTimestamp = "Wechatpay-Timestamp"
+	headerNonce     = "Wechatpay-Nonce"
+)
+
+// aead encrypted data, of certificates and notifications
+type EncryptedData struct {
+	Algorithm      string `json:"algorithm"` // AEAD_AES_256_GCM
+	Nonce          string `json:"nonce"`
+	AssociatedData string `json:"associated_data"`
+	Ciphertext     string `json:"ciphertext"`
+	OriginalType   string `json:"original_type,omitempty"`
+}
+
+// platform certificate listed by /v3/certificates
+type Certificate struct {
+	SerialNo           string            `json:"serial_no"`
+	EffectiveTime      time.Time         `json:"effective_time"`
+	ExpireTime         time.Time         `json:"expire_time"`
+	EncryptCertificate EncryptedData     `json:"encrypt_certificate"`
+	Certificate        *x509.Certificate `json:"-"`
+}
+
+// cache of platform certificates keyed by serial number
+type platformCerts struct {
+	mu      sync.RWMutex
+	certs   map[string]*x509.Certificate
+	fetched time.Time
+	fetchMu sync.Mutex
+}
+
+// add platform certificate, e.g. downloaded by the certificate tool,
+// or of a fake server in tests
+func (this *Client) AddCertificate(cert *x509.Certificate) {
+	this.certs.mu.Lock()
+	defer this.certs.mu.Unlock()
+	if this.certs.certs == nil {
+		this.certs.certs = make(map[string]*x509.Certificate)
+	}
+	this.certs.certs[serialNo(cert)] = cert
+}
+
+// fetch, decrypt and verify platform certificates, and add them to the cache
+func (this *Client) FetchCertificates(ctx context.Context) ([]Certificate, error) {
+	var rtn struct {
+		Data []Certificate `json:"data"`
+	}
+	var header http.Header
+	var body []byte
+	err := this.sendRaw(ctx, "GET", "/v3/certificates", nil, func(h http.Header, data []byte) error {
+		header, body = h, data
+		return json.Unmarshal(data, &rtn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	fetched := make(map[string]*x509.Certificate, len(rtn.Data))
+	for i := range rtn.Data {
+		c := &rtn.Data[i]
+		plain, err := this.Decrypt(&c.EncryptCertificate)
+		if err != nil {
+			return nil, err
+		}
+		if c.Certificate, err = parseCertificate(plain); err != nil {
+			return nil, err
+		}
+		fetched[normalizeSerial(c.SerialNo)] = c.Certificate
+	}
+	// the response is signed by one of the certificates it lists
+	cert, ok := fetched[normalizeSerial(header.Get(headerSerial))]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("certificates response signed by unknown certificate %s", header.Get(headerSerial)))
+	}
+	if err := verifyHeader(cert, header, body); err != nil {
+		return nil, err
+	}
+	this.certs.mu.Lock()
+	if this.certs.certs == nil {
+		this.certs.certs = make(map[string]*x509.Certificate)
+	}
+	for serial, cert := range fetched {
+		this.certs.certs[serial] = cert
+	}
+	this.certs.fetched = time.Now()
+	this.certs.mu.Unlock()
+	return rtn.Data, nil
+}
+
+// get platform certificate by serial number, fetched if unknown
+func (this *Client) certificate(ctx context.Context, serial string) (*x509.Certificate, error) {
+	serial = normalizeSerial(serial)
+	if cert, ok := this.cachedCertificate(serial); ok {
+		return cert, nil
+	}
+	this.certs.fetchMu.Lock()
+	defer this.certs.fetchMu.Unlock()
+	// fetched by another goroutine meanwhile
+	if cert, ok := this.cachedCertificate(serial); ok {
+		return cert, nil
+	}
+	this.certs.mu.RLock()
+	recent := time.Since(this.certs.fetched) < time.Minute
+	this.certs.mu.RUnlock()
+	if !recent {
+		if _, err := this.FetchCertificates(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if cert, ok := this.cachedCertificate(serial); ok {
+		return cert, nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown platform certificate %s", serial))
+}
+
+func (this *Client) cachedCertificate(serial string) (*x509.Certificate, bool) {
+	this.certs.mu.RLock()
+	defer this.certs.mu.RUnlock()
+	cert, ok := this.certs.certs[serial]
+	if !ok {
+		return nil, false
+	}
+	// certificates added by AddCertificate are never fetched
+	if !this.certs.fetched.IsZero() && time.Since(this.certs.fetched) > certRefresh {
+		return nil, false
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, false
+	}
+	return cert, true
+}
+
+// verify Wechatpay-* headers of response or notification against body
+func (this *Client) verify(ctx context.Context, header http.Header, body []byte) error {
+	serial := header.Get(headerSerial)
+	if serial == "" {
+		return errors.New("response is not signed")
+	}
+	cert, err := this.certificate(ctx, serial)
+	if err != nil {
+		return err
+	}
+	return verifyHeader(cert, header, body)
+}
+
+func verifyHeader(cert *x509.Certificate, header http.Header, body []byte) error {
+	timestamp := header.Get(headerTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid %s %q", headerTimestamp, timestamp))
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+		return errors.New(fmt.Sprintf("signature timestamp %d out of %s", ts, signatureMaxAge))
+	}
+	sig, err := base64.StdEncoding.DecodeString(header.Get(headerSignature))
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("platform certificate key is not rsa")
+	}
+	msg := timestamp + "\n" + header.Get(headerNonce) + "\n" + string(body) + "\n"
+	sum := sha256.Sum256([]byte(msg))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		return errors.New("invalid signature: " + err.Error())
+	}
+	return nil
+}
+
+// decrypt AEAD_AES_256_GCM data with APIv3Key
+func (this *Client) Decrypt(data *EncryptedData) ([]byte, error) {
+	if data.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, errors.New(fmt.Sprintf("unsupported algorithm %q", data.Algorithm))
+	}
+	if len(this.APIv3Key) != 32 {
+		return nil, errors.New("APIv3Key must be 32 bytes")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(this.APIv3Key))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(data.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, []byte(data.Nonce), ciphertext, []byte(data.AssociatedData))
+}
+
+func parseCertificate(pemData []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("certificate is not pem encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// serial number as key of certificate cache
+func serialNo(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// hex serial number as key of certificate cache, without leading zeros
+// which Wechatpay-Serial keeps
+func normalizeSerial(serial string) string {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return strings.ToUpper(serial)
+	}
+	return fmt.Sprintf("%X", n)
+}
@@ -0,0 +1,206 @@
+package pay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// event types of Notification
+const (
+	EventTransactionSuccess = "TRANSACTION.SUCCESS"
+	EventRefundSuccess      = "REFUND.SUCCESS"
+	EventRefundAbnormal     = "REFUND.ABNORMAL"
+	EventRefundClosed       = "REFUND.CLOSED"
+)
+
+// limit of notification body
+const notifyMaxBody = 1 << 20
+
+// notification of payment or refund, Plaintext is the decrypted resource
+type Notification struct {
+	Id           string        `json:"id"`
+	CreateTime   string        `json:"create_time"`
+	EventType    string        `json:"event_type"`
+	ResourceType string        `json:"resource_type"`
+	Summary      string        `json:"summary"`
+	Resource     EncryptedData `json:"resource"`
+	Plaintext    []byte        `json:"-"`
+}
+
+// verify signature of notification and decrypt its resource
+func (this *Client) ParseNotification(req *http.Request) (*Notification, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, req.Body, notifyMaxBody))
+	if err != nil {
+		return nil, err
+	}
+	defer req.Body.Close()
+	if err := this.verify(req.Context(), req.Header, body); err != nil {
+		return nil, err
+	}
+	var n Notification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, err
+	}
+	if n.Plaintext, err = this.Decrypt(&n.Resource); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// record of handled notifications, so retried notifications are handled once
+type NotifyStore interface {
+	Handled(key string) (bool, error)
+	SetHandled(key string) error
+}
+
+// optionally implemented by NotifyStore, to prevent concurrent handling of the same key
+type NotifyLocker interface {
+	Lock(key string) (unlock func(), err error)
+}
+
+// http.Handler of payment and refund notifications
+type NotifyHandler struct {
+	Client *Client
+	Store  NotifyStore // duplicates are handled again if nil
+	// called for TRANSACTION.SUCCESS, the notification fails if nil
+	OnTransaction func(ctx context.Context, n *Notification, t *Transaction) error
+	// called for REFUND.SUCCESS, REFUND.ABNORMAL and REFUND.CLOSED, the notification fails if nil
+	OnRefund func(ctx context.Context, n *Notification, r *Refund) error
+}
+
+func NewNotifyHandler(client *Client, store NotifyStore) *NotifyHandler {
+	return &NotifyHandler{Client: client, Store: store}
+}
+
+// responds 204 if handled or of unknown event type,
+// or an error so the notification is sent again
+func (this *NotifyHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		notifyFail(rw, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	n, err := this.Client.ParseNotification(req)
+	if err != nil {
+		notifyFail(rw, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err := this.handle(req.Context(), n); err != nil {
+		notifyFail(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (this *NotifyHandler) handle(ctx context.Context, n *Notification) error {
+	var key string
+	var call func() error
+	switch n.EventType {
+	case EventTransactionSuccess:
+		if this.OnTransaction == nil {
+			return errors.New("no handler of " + n.EventType)
+		}
+		var t Transaction
+		if err := json.Unmarshal(n.Plaintext, &t); err != nil {
+			return err
+		}
+		key = "transaction-" + t.TransactionId
+		call = func() error {
+			return this.OnTransaction(ctx, n, &t)
+		}
+	case EventRefundSuccess, EventRefundAbnormal, EventRefundClosed:
+		if this.OnRefund == nil {
+			return errors.New("no handler of " + n.EventType)
+		}
+		var r Refund
+		if err := json.Unmarshal(n.Plaintext, &r); err != nil {
+			return err
+		}
+		// a refund may be notified again with another status
+		key = "refund-" + r.RefundId + "-" + n.EventType
+		call = func() error {
+			return this.OnRefund(ctx, n, &r)
+		}
+	default:
+		// acknowledged, or sent again forever
+		return nil
+	}
+	if this.Store == nil {
+		return call()
+	}
+	if locker, ok := this.Store.(NotifyLocker); ok {
+		unlock, err := locker.Lock(key)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	handled, err := this.Store.Handled(key)
+	if err != nil {
+		return err
+	}
+	if handled {
+		return nil
+	}
+	if err := call(); err != nil {
+		return err
+	}
+	return this.Store.SetHandled(key)
+}
+
+func notifyFail(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(map[string]string{"code": "FAIL", "message": message})
+}
+
+// store handled keys in memory of current process
+type MemoryNotifyStore struct {
+	mu      sync.Mutex
+	handled map[string]bool
+	busy    map[string]chan struct{}
+}
+
+func (this *MemoryNotifyStore) Handled(key string) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.handled[key], nil
+}
+
+func (this *MemoryNotifyStore) SetHandled(key string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.handled == nil {
+		this.handled = make(map[string]bool)
+	}
+	this.handled[key] = true
+	return nil
+}
+
+func (this *MemoryNotifyStore) Lock(key string) (func(), error) {
+	this.mu.Lock()
+	for {
+		ch, ok := this.busy[key]
+		if !ok {
+			break
+		}
+		this.mu.Unlock()
+		<-ch
+		this.mu.Lock()
+	}
+	if this.busy == nil {
+		this.busy = make(map[string]chan struct{})
+	}
+	ch := make(chan struct{})
+	this.busy[key] = ch
+	this.mu.Unlock()
+	return func() {
+		this.mu.Lock()
+		delete(this.busy, key)
+		this.mu.Unlock()
+		close(ch)
+	}, nil
+}
@@ -0,0 +1,122 @@
+package pay
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signed notification of event with resource encrypted by APIv3Key
+func (this *fakeServer) notification(t *testing.T, client *Client, eventType, resource string) *http.Request {
+	block, err := aes.NewCipher([]byte(client.APIv3Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, ad := "fdasflkja484", "transaction"
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         "EV-2018022511223320873",
+		"event_type": eventType,
+		"resource": map[string]string{
+			"algorithm":       "AEAD_AES_256_GCM",
+			"nonce":           nonce,
+			"associated_data": ad,
+			"ciphertext":      base64.StdEncoding.EncodeToString(aead.Seal(nil, []byte(nonce), []byte(resource), []byte(ad))),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sum := sha256.Sum256([]byte(timestamp + "\nnonce\n" + string(body) + "\n"))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, this.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/notify", bytes.NewReader(body))
+	req.Header.Set(headerSerial, serialNo(this.cert))
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, "nonce")
+	req.Header.Set(headerSignature, base64.StdEncoding.EncodeToString(sig))
+	return req
+}
+
+func TestNotifyHandler(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	h := NewNotifyHandler(client, &MemoryNotifyStore{})
+	var paid []string
+	h.OnTransaction = func(ctx context.Context, n *Notification, tr *Transaction) error {
+		paid = append(paid, tr.OutTradeNo)
+		return nil
+	}
+	resource := `{"transaction_id":"1217752501201407033233368018","out_trade_no":"O1","trade_state":"SUCCESS"}`
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, srv.notification(t, client, EventTransactionSuccess, resource))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d %s", rec.Code, rec.Body)
+		}
+	}
+	if len(paid) != 1 || paid[0] != "O1" {
+		t.Errorf("handled %v, want once", paid)
+	}
+	// unknown event types are acknowledged
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, srv.notification(t, client, "UNKNOWN.EVENT", `{}`))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("unknown event status = %d", rec.Code)
+	}
+	// refunds fail without handler, so they are sent again
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, srv.notification(t, client, EventRefundSuccess, `{"refund_id":"R1"}`))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("refund without handler status = %d", rec.Code)
+	}
+	// tampered body
+	req := srv.notification(t, client, EventTransactionSuccess, resource)
+	req.Body = httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{"id":"x"}`))).Body
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("tampered status = %d", rec.Code)
+	}
+}
+
+func TestRefundNotification(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	h := NewNotifyHandler(client, nil)
+	var got *Refund
+	h.OnRefund = func(ctx context.Context, n *Notification, r *Refund) error {
+		got = r
+		return nil
+	}
+	resource := `{"mchid":"1230000109","transaction_id":"1217752501201407033233368018","out_trade_no":"O1",` +
+		`"refund_id":"50000000382019052709732678859","out_refund_no":"R1","refund_status":"SUCCESS",` +
+		`"success_time":"2018-06-08T10:34:56+08:00","user_received_account":"招商银行信用卡0403",` +
+		`"amount":{"total":999,"refund":999,"payer_total":999,"payer_refund":999}}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, srv.notification(t, client, EventRefundSuccess, resource))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d %s", rec.Code, rec.Body)
+	}
+	if got == nil || got.RefundStatus != RefundStatusSuccess || got.OutRefundNo != "R1" || got.Amount.PayerRefund != 999 {
+		t.Errorf("refund = %+v", got)
+	}
+}
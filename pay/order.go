@@ -0,0 +1,292 @@
+package pay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sidbusy/weixinmp"
+)
+
+// trade states of Transaction
+const (
+	TradeStateSuccess    = "SUCCESS"
+	TradeStateRefund     = "REFUND"
+	TradeStateNotPay     = "NOTPAY"
+	TradeStateClosed     = "CLOSED"
+	TradeStateRevoked    = "REVOKED"
+	TradeStateUserPaying = "USERPAYING"
+	TradeStatePayError   = "PAYERROR"
+)
+
+// statuses of Refund
+const (
+	RefundStatusSuccess    = "SUCCESS"
+	RefundStatusClosed     = "CLOSED"
+	RefundStatusProcessing = "PROCESSING"
+	RefundStatusAbnormal   = "ABNORMAL"
+)
+
+// amount in fen (分)
+type Amount struct {
+	Total    int64  `json:"total"`
+	Currency string `json:"currency,omitempty"` // CNY if empty
+}
+
+type Payer struct {
+	OpenId string `json:"openid"`
+}
+
+type SceneInfo struct {
+	PayerClientIp string  `json:"payer_client_ip"`
+	DeviceId      string  `json:"device_id,omitempty"`
+	H5Info        *H5Info `json:"h5_info,omitempty"`
+}
+
+// required by H5 orders
+type H5Info struct {
+	Type    string `json:"type"` // Wap, iOS or Android
+	AppName string `json:"app_name,omitempty"`
+	AppUrl  string `json:"app_url,omitempty"`
+}
+
+// order to create, AppId, MchId and NotifyUrl default to config
+type Order struct {
+	AppId       string     `json:"appid"`
+	MchId       string     `json:"mchid"`
+	Description string     `json:"description"`
+	OutTradeNo  string     `json:"out_trade_no"`
+	TimeExpire  string     `json:"time_expire,omitempty"` // rfc3339
+	Attach      string     `json:"attach,omitempty"`
+	NotifyUrl   string     `json:"notify_url"`
+	GoodsTag    string     `json:"goods_tag,omitempty"`
+	Amount      Amount     `json:"amount"`
+	Payer       *Payer     `json:"payer,omitempty"`      // required by JSAPI orders
+	SceneInfo   *SceneInfo `json:"scene_info,omitempty"` // required by H5 orders
+}
+
+// order queried or notified
+type Transaction struct {
+	AppId          string `json:"appid"`
+	MchId          string `json:"mchid"`
+	OutTradeNo     string `json:"out_trade_no"`
+	TransactionId  string `json:"transaction_id"`
+	TradeType      string `json:"trade_type"`
+	TradeState     string `json:"trade_state"`
+	TradeStateDesc string `json:"trade_state_desc"`
+	BankType       string `json:"bank_type"`
+	Attach         string `json:"attach"`
+	SuccessTime    string `json:"success_time"`
+	Payer          Payer  `json:"payer"`
+	Amount         struct {
+		Total         int64  `json:"total"`
+		PayerTotal    int64  `json:"payer_total"`
+		Currency      string `json:"currency"`
+		PayerCurrency string `json:"payer_currency"`
+	} `json:"amount"`
+}
+
+// refund to create, by TransactionId or OutTradeNo
+type RefundRequest struct {
+	TransactionId string `json:"transaction_id,omitempty"`
+	OutTradeNo    string `json:"out_trade_no,omitempty"`
+	OutRefundNo   string `json:"out_refund_no"`
+	Reason        string `json:"reason,omitempty"`
+	NotifyUrl     string `json:"notify_url,omitempty"`
+	Amount        struct {
+		Refund   int64  `json:"refund"`
+		Total    int64  `json:"total"`
+		Currency string `json:"currency"`
+	} `json:"amount"`
+}
+
+// refund created, queried or notified
+type Refund struct {
+	RefundId            string `json:"refund_id"`
+	OutRefundNo         string `json:"out_refund_no"`
+	TransactionId       string `json:"transaction_id"`
+	OutTradeNo          string `json:"out_trade_no"`
+	Channel             string `json:"channel"`
+	UserReceivedAccount string `json:"user_received_account"`
+	SuccessTime         string `json:"success_time"`
+	CreateTime          string `json:"create_time"`
+	Status              string `json:"status"`        // of Refund and QueryRefund
+	RefundStatus        string `json:"refund_status"` // of refund notifications
+	Amount              struct {
+		Total       int64  `json:"total"`
+		Refund      int64  `json:"refund"`
+		PayerTotal  int64  `json:"payer_total"`
+		PayerRefund int64  `json:"payer_refund"`
+		Currency    string `json:"currency"`
+	} `json:"amount"`
+}
+
+// parameters of WeixinJSBridge.invoke('getBrandWCPayRequest')
+type JSAPIParams struct {
+	AppId     string `json:"appId"`
+	TimeStamp string `json:"timeStamp"`
+	NonceStr  string `json:"nonceStr"`
+	Package   string `json:"package"`
+	SignType  string `json:"signType"`
+	PaySign   string `json:"paySign"`
+}
+
+// parameters of wx.chooseWXPay, which names timeStamp as timestamp
+func (this *JSAPIParams) ChooseWXPay() map[string]string {
+	return map[string]string{
+		"timestamp": this.TimeStamp,
+		"nonceStr":  this.NonceStr,
+		"package":   this.Package,
+		"signType":  this.SignType,
+		"paySign":   this.PaySign,
+	}
+}
+
+// create JSAPI order, returns prepay_id
+func (this *Client) JSAPIOrder(ctx context.Context, order *Order) (string, error) {
+	if order.Payer == nil || order.Payer.OpenId == "" {
+		return "", errors.New("JSAPI order requires payer openid")
+	}
+	var rtn struct {
+		PrepayId string `json:"prepay_id"`
+	}
+	if err := this.do(ctx, "POST", "/v3/pay/transactions/jsapi", this.order(order), &rtn); err != nil {
+		return "", err
+	}
+	return rtn.PrepayId, nil
+}
+
+// create Native order, returns code_url to show as qrcode
+func (this *Client) NativeOrder(ctx context.Context, order *Order) (string, error) {
+	var rtn struct {
+		CodeUrl string `json:"code_url"`
+	}
+	if err := this.do(ctx, "POST", "/v3/pay/transactions/native", this.order(order), &rtn); err != nil {
+		return "", err
+	}
+	return rtn.CodeUrl, nil
+}
+
+// create H5 order, returns h5_url to open in mobile browser
+func (this *Client) H5Order(ctx context.Context, order *Order) (string, error) {
+	if order.SceneInfo == nil || order.SceneInfo.H5Info == nil {
+		return "", errors.New("H5 order requires scene info")
+	}
+	var rtn struct {
+		H5Url string `json:"h5_url"`
+	}
+	if err := this.do(ctx, "POST", "/v3/pay/transactions/h5", this.order(order), &rtn); err != nil {
+		return "", err
+	}
+	return rtn.H5Url, nil
+}
+
+// create JSAPI order paid by the user authorized by weixinmp.OAuth.Middleware,
+// returns parameters of the pay page
+func (this *Client) JSAPIPay(req *http.Request, order *Order) (*JSAPIParams, error) {
+	openId := weixinmp.OpenIdFromContext(req.Context())
+	if openId == "" {
+		return nil, errors.New("no openid in request context")
+	}
+	o := *order
+	o.Payer = &Payer{OpenId: openId}
+	prepayId, err := this.JSAPIOrder(req.Context(), &o)
+	if err != nil {
+		return nil, err
+	}
+	return this.JSAPIParams(prepayId)
+}
+
+// sign parameters of pay page for prepay_id
+func (this *Client) JSAPIParams(prepayId string) (*JSAPIParams, error) {
+	nonce, err := nonceStr()
+	if err != nil {
+		return nil, err
+	}
+	params := &JSAPIParams{
+		AppId:     this.AppId,
+		TimeStamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  nonce,
+		Package:   "prepay_id=" + prepayId,
+		SignType:  "RSA",
+	}
+	if params.PaySign, err = this.sign(params.AppId, params.TimeStamp, params.NonceStr, params.Package); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// query order by out_trade_no
+func (this *Client) QueryOrder(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	var rtn Transaction
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "?mchid=" + url.QueryEscape(this.MchId)
+	if err := this.do(ctx, "GET", path, nil, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// query order by transaction_id
+func (this *Client) QueryOrderByTransactionId(ctx context.Context, transactionId string) (*Transaction, error) {
+	var rtn Transaction
+	path := "/v3/pay/transactions/id/" + url.PathEscape(transactionId) + "?mchid=" + url.QueryEscape(this.MchId)
+	if err := this.do(ctx, "GET", path, nil, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// close unpaid order
+func (this *Client) CloseOrder(ctx context.Context, outTradeNo string) error {
+	var req struct {
+		MchId string `json:"mchid"`
+	}
+	req.MchId = this.MchId
+	return this.do(ctx, "POST", "/v3/pay/transactions/out-trade-no/"+url.PathEscape(outTradeNo)+"/close", &req, nil)
+}
+
+// create refund, NotifyUrl defaults to config
+func (this *Client) Refund(ctx context.Context, req *RefundRequest) (*Refund, error) {
+	if req.TransactionId == "" && req.OutTradeNo == "" {
+		return nil, errors.New("refund requires transaction_id or out_trade_no")
+	}
+	r := *req
+	if r.NotifyUrl == "" {
+		r.NotifyUrl = this.NotifyUrl
+	}
+	if r.Amount.Currency == "" {
+		r.Amount.Currency = "CNY"
+	}
+	var rtn Refund
+	if err := this.do(ctx, "POST", "/v3/refund/domestic/refunds", &r, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// query refund by out_refund_no
+func (this *Client) QueryRefund(ctx context.Context, outRefundNo string) (*Refund, error) {
+	var rtn Refund
+	if err := this.do(ctx, "GET", "/v3/refund/domestic/refunds/"+url.PathEscape(outRefundNo), nil, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// copy of order with defaults from config
+func (this *Client) order(order *Order) *Order {
+	o := *order
+	if o.AppId == "" {
+		o.AppId = this.AppId
+	}
+	if o.MchId == "" {
+		o.MchId = this.MchId
+	}
+	if o.NotifyUrl == "" {
+		o.NotifyUrl = this.NotifyUrl
+	}
+	return &o
+}
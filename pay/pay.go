@@ -0,0 +1,202 @@
+// Package pay is a client of WeChat Pay api v3 for merchants of
+// official accounts: JSAPI, Native and H5 orders, refunds, and
+// verification of platform certificates.
+package pay
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	BaseURL = "https://api.mch.weixin.qq.com"
+	// signature scheme of Authorization header
+	authSchema = "WECHATPAY2-SHA256-RSA2048"
+)
+
+// merchant config
+type Config struct {
+	MchId      string
+	AppId      string // appid of official account
+	SerialNo   string // serial number of merchant api certificate
+	PrivateKey *rsa.PrivateKey
+	APIv3Key   string // 32 bytes
+	NotifyUrl  string // default notify_url of orders and refunds
+}
+
+// api v3 client
+type Client struct {
+	Config
+	BaseURL    string       // BaseURL if empty, set to fake server in tests
+	HTTPClient *http.Client // http.DefaultClient if nil
+	certs      platformCerts
+}
+
+func NewClient(cfg *Config) *Client {
+	return &Client{Config: *cfg}
+}
+
+// error returned by api, with http status and error code such as ORDERNOTEXIST
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("%d %s %s", this.StatusCode, this.Code, this.Message)
+}
+
+// parse rsa private key from pem of apiclient_key.pem
+func ParsePrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("private key is not pem encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not rsa")
+	}
+	return rsaKey, nil
+}
+
+// load rsa private key from pem file
+func LoadPrivateKey(fileName string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// call api with json body, verify signature of response and unmarshal it into rtn
+func (this *Client) do(ctx context.Context, method, path string, req, rtn interface{}) error {
+	return this.sendRaw(ctx, method, path, req, func(header http.Header, data []byte) error {
+		if err := this.verify(ctx, header, data); err != nil {
+			return err
+		}
+		if rtn == nil || len(data) == 0 {
+			return nil
+		}
+		return json.Unmarshal(data, rtn)
+	})
+}
+
+// call api with signed request, and pass successful response to handle
+func (this *Client) sendRaw(ctx context.Context, method, path string, req interface{}, handle func(http.Header, []byte) error) error {
+	var body []byte
+	if req != nil {
+		var err error
+		if body, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	httpReq, err := http.NewRequest(method, this.baseURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	auth, err := this.authorization(method, path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", auth)
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := this.httpClient().Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(data, e)
+		return e
+	}
+	return handle(resp.Header, data)
+}
+
+// Authorization header of request
+func (this *Client) authorization(method, path string, body []byte) (string, error) {
+	nonce, err := nonceStr()
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, err := this.sign(method, path, timestamp, nonce, string(body))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",timestamp="%s",serial_no="%s",signature="%s"`,
+		authSchema, this.MchId, nonce, timestamp, this.SerialNo, sig), nil
+}
+
+// sign lines with merchant private key, each line ends with "\n"
+func (this *Client) sign(lines ...string) (string, error) {
+	if this.PrivateKey == nil {
+		return "", errors.New("merchant private key is not set")
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, this.PrivateKey, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (this *Client) baseURL() string {
+	if this.BaseURL == "" {
+		return BaseURL
+	}
+	return this.BaseURL
+}
+
+func (this *Client) httpClient() *http.Client {
+	if this.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return this.HTTPClient
+}
+
+// random 32 hex chars
+func nonceStr() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,218 @@
+package pay
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fake pay server signing responses with a generated platform certificate
+type fakeServer struct {
+	*httptest.Server
+	t        *testing.T
+	key      *rsa.PrivateKey // platform key
+	cert     *x509.Certificate
+	mchKey   *rsa.PublicKey
+	tamper   bool
+	requests map[string]string // path -> body
+}
+
+func newFakeServer(t *testing.T, mchKey *rsa.PublicKey) *fakeServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// serial with leading zero nibbles, kept in Wechatpay-Serial
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x0ABCDEF),
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{t: t, key: key, cert: cert, mchKey: mchKey, requests: make(map[string]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+var authRe = regexp.MustCompile(`^WECHATPAY2-SHA256-RSA2048 mchid="([^"]*)",nonce_str="([^"]*)",timestamp="([^"]*)",serial_no="([^"]*)",signature="([^"]*)"$`)
+
+func (this *fakeServer) serve(rw http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	m := authRe.FindStringSubmatch(req.Header.Get("Authorization"))
+	if m == nil {
+		this.reply(rw, http.StatusUnauthorized, `{"code":"SIGN_ERROR","message":"no authorization"}`)
+		return
+	}
+	sig, _ := base64.StdEncoding.DecodeString(m[5])
+	msg := req.Method + "\n" + req.URL.RequestURI() + "\n" + m[3] + "\n" + m[2] + "\n" + string(body) + "\n"
+	sum := sha256.Sum256([]byte(msg))
+	if err := rsa.VerifyPKCS1v15(this.mchKey, crypto.SHA256, sum[:], sig); err != nil {
+		this.reply(rw, http.StatusUnauthorized, `{"code":"SIGN_ERROR","message":"invalid signature"}`)
+		return
+	}
+	this.requests[req.URL.Path] = string(body)
+	switch {
+	case req.URL.Path == "/v3/pay/transactions/jsapi":
+		this.reply(rw, http.StatusOK, `{"prepay_id":"wx201410272009395522657a690389285100"}`)
+	case strings.HasSuffix(req.URL.Path, "/close"):
+		this.reply(rw, http.StatusNoContent, "")
+	case req.URL.Path == "/v3/refund/domestic/refunds":
+		this.reply(rw, http.StatusOK, `{"refund_id":"50000000382019052709732678859","out_refund_no":"R1","status":"PROCESSING","amount":{"total":100,"refund":100,"currency":"CNY"}}`)
+	default:
+		this.reply(rw, http.StatusNotFound, `{"code":"ORDER_NOT_EXIST","message":"order not exist"}`)
+	}
+}
+
+func (this *fakeServer) reply(rw http.ResponseWriter, status int, body string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := "5K8264ILTKCH16CQ2502SI8ZNMTM67VS"
+	sum := sha256.Sum256([]byte(timestamp + "\n" + nonce + "\n" + body + "\n"))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, this.key, crypto.SHA256, sum[:])
+	if err != nil {
+		this.t.Fatal(err)
+	}
+	if this.tamper {
+		sig[0] ^= 0xff
+	}
+	rw.Header().Set(headerSerial, "000ABCDEF")
+	rw.Header().Set(headerTimestamp, timestamp)
+	rw.Header().Set(headerNonce, nonce)
+	rw.Header().Set(headerSignature, base64.StdEncoding.EncodeToString(sig))
+	if body != "" {
+		rw.Header().Set("Content-Type", "application/json")
+	}
+	rw.WriteHeader(status)
+	rw.Write([]byte(body))
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeServer) {
+	mchKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newFakeServer(t, &mchKey.PublicKey)
+	client := NewClient(&Config{
+		MchId:      "1230000109",
+		AppId:      "wxd678efh567hg6787",
+		SerialNo:   "5157F09EFDC096DE15EBE81A47057A7232F1B8E1",
+		PrivateKey: mchKey,
+		APIv3Key:   "0123456789abcdef0123456789abcdef",
+		NotifyUrl:  "https://example.com/notify",
+	})
+	client.BaseURL = srv.URL
+	client.AddCertificate(srv.cert)
+	return client, srv
+}
+
+func TestJSAPIOrder(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	prepayId, err := client.JSAPIOrder(context.Background(), &Order{
+		Description: "Image形象店-深圳腾大-QQ公仔",
+		OutTradeNo:  "1217752501201407033233368018",
+		Amount:      Amount{Total: 100},
+		Payer:       &Payer{OpenId: "oUpF8uMuAJO_M2pxb1Q9zNjWeS6o"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prepayId != "wx201410272009395522657a690389285100" {
+		t.Errorf("prepay_id = %q", prepayId)
+	}
+	var sent Order
+	if err := json.Unmarshal([]byte(srv.requests["/v3/pay/transactions/jsapi"]), &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.AppId != client.AppId || sent.MchId != client.MchId || sent.NotifyUrl != client.NotifyUrl {
+		t.Errorf("defaults not filled: %+v", sent)
+	}
+	params, err := client.JSAPIParams(prepayId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(params.PaySign)
+	sum := sha256.Sum256([]byte(params.AppId + "\n" + params.TimeStamp + "\n" + params.NonceStr + "\n" + params.Package + "\n"))
+	if err := rsa.VerifyPKCS1v15(&client.PrivateKey.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("paySign: %v", err)
+	}
+}
+
+func TestCloseOrder(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	if err := client.CloseOrder(context.Background(), "1217752501201407033233368018"); err != nil {
+		t.Fatal(err)
+	}
+	if body := srv.requests["/v3/pay/transactions/out-trade-no/1217752501201407033233368018/close"]; body != `{"mchid":"1230000109"}` {
+		t.Errorf("close body = %s", body)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	req := &RefundRequest{OutTradeNo: "1217752501201407033233368018", OutRefundNo: "R1"}
+	req.Amount.Refund = 100
+	req.Amount.Total = 100
+	refund, err := client.Refund(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != RefundStatusProcessing || refund.Amount.Refund != 100 {
+		t.Errorf("refund = %+v", refund)
+	}
+	if _, err := client.Refund(context.Background(), &RefundRequest{OutRefundNo: "R2"}); err == nil {
+		t.Error("refund without order accepted")
+	}
+}
+
+func TestError(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	_, err := client.QueryOrder(context.Background(), "nonexistent")
+	e, ok := err.(*Error)
+	if !ok || e.StatusCode != http.StatusNotFound || e.Code != "ORDER_NOT_EXIST" {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestTamperedSignature(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	srv.tamper = true
+	_, err := client.JSAPIOrder(context.Background(), &Order{
+		Description: "d",
+		OutTradeNo:  "o",
+		Amount:      Amount{Total: 1},
+		Payer:       &Payer{OpenId: "o"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+		t.Errorf("err = %v", err)
+	}
+	if err := client.CloseOrder(context.Background(), "o"); err == nil {
+		t.Error("tampered empty response accepted")
+	}
+}
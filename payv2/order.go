@@ -0,0 +1,162 @@
+package payv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+)
+
+// trade types
+const (
+	TradeTypeJSAPI  = "JSAPI"
+	TradeTypeNative = "NATIVE"
+	TradeTypeMWEB   = "MWEB" // H5
+	TradeTypeApp    = "APP"
+)
+
+// bill types of DownloadBill
+const (
+	BillTypeAll     = "ALL"
+	BillTypeSuccess = "SUCCESS"
+	BillTypeRefund  = "REFUND"
+)
+
+// order to create, amounts in fen (分)
+type UnifiedOrder struct {
+	XMLName        xml.Name `xml:"xml"`
+	DeviceInfo     string   `xml:"device_info,omitempty"`
+	Body           string   `xml:"body"`
+	Detail         string   `xml:"detail,omitempty"`
+	Attach         string   `xml:"attach,omitempty"`
+	OutTradeNo     string   `xml:"out_trade_no"`
+	FeeType        string   `xml:"fee_type,omitempty"`
+	TotalFee       int64    `xml:"total_fee"`
+	SpbillCreateIp string   `xml:"spbill_create_ip"`
+	TimeStart      string   `xml:"time_start,omitempty"`  // yyyyMMddHHmmss
+	TimeExpire     string   `xml:"time_expire,omitempty"` // yyyyMMddHHmmss
+	GoodsTag       string   `xml:"goods_tag,omitempty"`
+	NotifyUrl      string   `xml:"notify_url"`
+	TradeType      string   `xml:"trade_type"`
+	ProductId      string   `xml:"product_id,omitempty"` // required by NATIVE
+	OpenId         string   `xml:"openid,omitempty"`     // required by JSAPI
+	SceneInfo      string   `xml:"scene_info,omitempty"` // json, required by MWEB
+}
+
+type UnifiedOrderResult struct {
+	Result
+	DeviceInfo string `xml:"device_info"`
+	TradeType  string `xml:"trade_type"`
+	PrepayId   string `xml:"prepay_id"`
+	CodeUrl    string `xml:"code_url"`
+	MwebUrl    string `xml:"mweb_url"`
+}
+
+type OrderQueryResult struct {
+	Result
+	DeviceInfo         string `xml:"device_info"`
+	OpenId             string `xml:"openid"`
+	IsSubscribe        string `xml:"is_subscribe"`
+	TradeType          string `xml:"trade_type"`
+	TradeState         string `xml:"trade_state"`
+	TradeStateDesc     string `xml:"trade_state_desc"`
+	BankType           string `xml:"bank_type"`
+	TotalFee           int64  `xml:"total_fee"`
+	SettlementTotalFee int64  `xml:"settlement_total_fee"`
+	FeeType            string `xml:"fee_type"`
+	CashFee            int64  `xml:"cash_fee"`
+	TransactionId      string `xml:"transaction_id"`
+	OutTradeNo         string `xml:"out_trade_no"`
+	Attach             string `xml:"attach"`
+	TimeEnd            string `xml:"time_end"`
+}
+
+// refund to create, by TransactionId or OutTradeNo
+type RefundRequest struct {
+	XMLName       xml.Name `xml:"xml"`
+	TransactionId string   `xml:"transaction_id,omitempty"`
+	OutTradeNo    string   `xml:"out_trade_no,omitempty"`
+	OutRefundNo   string   `xml:"out_refund_no"`
+	TotalFee      int64    `xml:"total_fee"`
+	RefundFee     int64    `xml:"refund_fee"`
+	RefundFeeType string   `xml:"refund_fee_type,omitempty"`
+	RefundDesc    string   `xml:"refund_desc,omitempty"`
+	NotifyUrl     string   `xml:"notify_url,omitempty"`
+}
+
+type RefundResult struct {
+	Result
+	TransactionId string `xml:"transaction_id"`
+	OutTradeNo    string `xml:"out_trade_no"`
+	OutRefundNo   string `xml:"out_refund_no"`
+	RefundId      string `xml:"refund_id"`
+	RefundFee     int64  `xml:"refund_fee"`
+	TotalFee      int64  `xml:"total_fee"`
+	CashFee       int64  `xml:"cash_fee"`
+}
+
+// create order
+func (this *Client) UnifiedOrder(ctx context.Context, order *UnifiedOrder) (*UnifiedOrderResult, error) {
+	var rtn UnifiedOrderResult
+	if err := this.call(ctx, "/pay/unifiedorder", order, &rtn, 0); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// query order by transactionId or outTradeNo, the other one may be empty
+func (this *Client) OrderQuery(ctx context.Context, transactionId, outTradeNo string) (*OrderQueryResult, error) {
+	var rtn OrderQueryResult
+	if err := this.call(ctx, "/pay/orderquery", tradeNo(transactionId, outTradeNo), &rtn, 0); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// close unpaid order
+func (this *Client) CloseOrder(ctx context.Context, outTradeNo string) error {
+	return this.call(ctx, "/pay/closeorder", tradeNo("", outTradeNo), nil, 0)
+}
+
+// create refund, requires merchant certificate
+func (this *Client) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	var rtn RefundResult
+	if err := this.call(ctx, "/secapi/pay/refund", req, &rtn, withCert); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// download bill of billDate (yyyyMMdd) as csv text into w
+func (this *Client) DownloadBill(ctx context.Context, billDate, billType string, w io.Writer) error {
+	params := Params{
+		"appid":     this.AppId,
+		"mch_id":    this.MchId,
+		"bill_date": billDate,
+		"bill_type": billType,
+	}
+	data, err := this.send(ctx, "/pay/downloadbill", params, 0)
+	if err != nil {
+		return err
+	}
+	// errors are returned as xml, bills as csv text
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<xml>")) {
+		var resp Params
+		if err := xml.Unmarshal(data, &resp); err != nil {
+			return err
+		}
+		return responseError(resp)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func tradeNo(transactionId, outTradeNo string) Params {
+	params := Params{}
+	if transactionId != "" {
+		params["transaction_id"] = transactionId
+	} else {
+		params["out_trade_no"] = outTradeNo
+	}
+	return params
+}
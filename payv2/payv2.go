@@ -0,0 +1,324 @@
+// Package payv2 is a client of the legacy WeChat Pay xml api (v2),
+// signed by MD5 or HMAC-SHA256 with the merchant key.
+package payv2
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	BaseURL = "https://api.mch.weixin.qq.com"
+	// sign types
+	SignTypeMD5        = "MD5"
+	SignTypeHMACSHA256 = "HMAC-SHA256"
+	// return_code and result_code
+	CodeSuccess = "SUCCESS"
+	CodeFail    = "FAIL"
+)
+
+// flat xml document of request and response, <xml><key>value</key></xml>
+type Params map[string]string
+
+func (this Params) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{Name: xml.Name{Local: "xml"}}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range this.keys() {
+		if err := e.EncodeElement(this[k], xml.StartElement{Name: xml.Name{Local: k}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (this *Params) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if *this == nil {
+		*this = make(Params)
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var v string
+			if err := d.DecodeElement(&v, &tok); err != nil {
+				return err
+			}
+			(*this)[tok.Name.Local] = v
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (this Params) keys() []string {
+	keys := make([]string, 0, len(this))
+	for k := range this {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sign params with merchant key, MD5 if signType is empty
+func Sign(params Params, key, signType string) string {
+	var buf bytes.Buffer
+	for _, k := range params.keys() {
+		if k == "sign" || params[k] == "" {
+			continue
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		buf.WriteString(params[k])
+		buf.WriteByte('&')
+	}
+	buf.WriteString("key=")
+	buf.WriteString(key)
+	var h hash.Hash
+	if signType == SignTypeHMACSHA256 {
+		h = hmac.New(sha256.New, []byte(key))
+	} else {
+		h = md5.New()
+	}
+	h.Write(buf.Bytes())
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// verify sign of params, e.g. of a pay notification
+func Verify(params Params, key, signType string) bool {
+	return hmac.Equal([]byte(params["sign"]), []byte(Sign(params, key, signType)))
+}
+
+// random 32 hex chars
+func NonceStr() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// error returned by api, either of communication (return_code)
+// or of business (result_code and err_code)
+type Error struct {
+	ReturnCode string
+	ReturnMsg  string
+	ResultCode string
+	ErrCode    string
+	ErrCodeDes string
+}
+
+func (this *Error) Error() string {
+	if this.ReturnCode != CodeSuccess {
+		return this.ReturnCode + " " + this.ReturnMsg
+	}
+	return this.ErrCode + " " + this.ErrCodeDes
+}
+
+// error after the request is sent, of transport or of a response that cannot be
+// read or trusted, the request may have been handled
+type ResponseError struct {
+	Err error
+}
+
+func (this *ResponseError) Error() string {
+	return this.Err.Error()
+}
+
+func (this *ResponseError) Unwrap() error {
+	return this.Err
+}
+
+// common fields of responses
+type Result struct {
+	ReturnCode string `xml:"return_code"`
+	ReturnMsg  string `xml:"return_msg"`
+	AppId      string `xml:"appid"`
+	MchId      string `xml:"mch_id"`
+	NonceStr   string `xml:"nonce_str"`
+	Sign       string `xml:"sign"`
+	ResultCode string `xml:"result_code"`
+	ErrCode    string `xml:"err_code"`
+	ErrCodeDes string `xml:"err_code_des"`
+}
+
+// api v2 client
+type Client struct {
+	AppId    string
+	MchId    string
+	Key      string // merchant api key
+	SignType string // SignTypeMD5 if empty
+	BaseURL  string // BaseURL if empty
+	// http.DefaultClient if nil
+	HTTPClient *http.Client
+	// client with merchant certificate, for refunds and payouts
+	TLSClient *http.Client
+}
+
+func NewClient(appId, mchId, key string) *Client {
+	return &Client{AppId: appId, MchId: mchId, Key: key}
+}
+
+// load merchant certificate (apiclient_cert.pem and apiclient_key.pem) into TLSClient
+func (this *Client) LoadCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	this.TLSClient = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		},
+	}
+	return nil
+}
+
+// options of a call
+type callOption int
+
+const (
+	withCert   callOption = 1 << iota // request with merchant certificate
+	skipVerify                        // response is not signed
+)
+
+// call api with signed params of req, verify response and unmarshal it into rtn
+func (this *Client) call(ctx context.Context, path string, req, rtn interface{}, opts callOption) error {
+	params, err := toParams(req)
+	if err != nil {
+		return err
+	}
+	if _, ok := params["appid"]; !ok {
+		params["appid"] = this.AppId
+	}
+	params["mch_id"] = this.MchId
+	return this.post(ctx, path, params, rtn, opts)
+}
+
+// post signed params, verify response and unmarshal it into rtn
+func (this *Client) post(ctx context.Context, path string, params Params, rtn interface{}, opts callOption) error {
+	data, err := this.send(ctx, path, params, opts)
+	if err != nil {
+		return err
+	}
+	var resp Params
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return &ResponseError{err}
+	}
+	if resp["return_code"] != CodeSuccess {
+		return responseError(resp)
+	}
+	if opts&skipVerify == 0 && !Verify(resp, this.Key, this.signType()) {
+		return &ResponseError{errors.New("invalid sign of response")}
+	}
+	if resp["result_code"] != "" && resp["result_code"] != CodeSuccess {
+		return responseError(resp)
+	}
+	if rtn == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, rtn); err != nil {
+		return &ResponseError{err}
+	}
+	return nil
+}
+
+// error of failed response
+func responseError(resp Params) *Error {
+	return &Error{
+		ReturnCode: resp["return_code"],
+		ReturnMsg:  resp["return_msg"],
+		ResultCode: resp["result_code"],
+		ErrCode:    resp["err_code"],
+		ErrCodeDes: resp["err_code_des"],
+	}
+}
+
+// sign params and post them, returns the raw response
+func (this *Client) send(ctx context.Context, path string, params Params, opts callOption) ([]byte, error) {
+	nonce, err := NonceStr()
+	if err != nil {
+		return nil, err
+	}
+	params["nonce_str"] = nonce
+	if this.signType() == SignTypeHMACSHA256 {
+		params["sign_type"] = SignTypeHMACSHA256
+	}
+	params["sign"] = Sign(params, this.Key, this.signType())
+	body, err := xml.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	client := this.HTTPClient
+	if opts&withCert != 0 {
+		if this.TLSClient == nil {
+			return nil, errors.New("merchant certificate is not loaded")
+		}
+		client = this.TLSClient
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpReq, err := http.NewRequest("POST", this.baseURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, &ResponseError{err}
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &ResponseError{err}
+	}
+	return data, nil
+}
+
+func (this *Client) signType() string {
+	if this.SignType == "" {
+		return SignTypeMD5
+	}
+	return this.SignType
+}
+
+func (this *Client) baseURL() string {
+	if this.BaseURL == "" {
+		return BaseURL
+	}
+	return this.BaseURL
+}
+
+// convert request struct to params, by its xml tags
+func toParams(req interface{}) (Params, error) {
+	data, err := xml.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var params Params
+	if err := xml.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,166 @@
+package payv2
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testKey = "192006250b4c09247ec02edce69f6a2d"
+
+// fake pay server checking request signs, replies are keyed by path
+type fakeServer struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	replies  map[string]Params
+	signed   bool          // sign replies
+	delay    time.Duration // wait before replying
+	requests map[string]Params
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t, replies: make(map[string]Params), signed: true, requests: make(map[string]Params)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (this *fakeServer) serve(rw http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	var params Params
+	if err := xml.Unmarshal(body, &params); err != nil || !Verify(params, testKey, params["sign_type"]) {
+		this.reply(rw, Params{"return_code": CodeFail, "return_msg": "签名错误"})
+		return
+	}
+	this.mu.Lock()
+	this.requests[req.URL.Path] = params
+	this.mu.Unlock()
+	time.Sleep(this.delay)
+	resp, ok := this.replies[req.URL.Path]
+	if !ok {
+		resp = Params{"return_code": CodeFail, "return_msg": "not found"}
+	}
+	this.reply(rw, resp)
+}
+
+func (this *fakeServer) reply(rw http.ResponseWriter, resp Params) {
+	out := Params{}
+	for k, v := range resp {
+		out[k] = v
+	}
+	if this.signed && out["return_code"] == CodeSuccess {
+		out["sign"] = Sign(out, testKey, SignTypeMD5)
+	}
+	data, err := xml.Marshal(out)
+	if err != nil {
+		this.t.Fatal(err)
+	}
+	rw.Write(data)
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeServer) {
+	srv := newFakeServer(t)
+	client := NewClient("wxd930ea5d5a258f4f", "10000100", testKey)
+	client.BaseURL = srv.URL
+	client.TLSClient = srv.Client()
+	return client, srv
+}
+
+func success(params Params) Params {
+	params["return_code"] = CodeSuccess
+	params["result_code"] = CodeSuccess
+	return params
+}
+
+func TestSendRedPack(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	srv.replies["/mmpaymkttransfers/sendredpack"] = success(Params{"mch_billno": "B1", "send_listid": "L1", "total_amount": "100"})
+	rtn, err := client.SendRedPack(context.Background(), &RedPack{MchBillno: "B1", TotalAmount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtn.SendListId != "L1" || rtn.TotalAmount != 100 {
+		t.Errorf("result = %+v", rtn)
+	}
+	sent := srv.requests["/mmpaymkttransfers/sendredpack"]
+	if sent["wxappid"] != client.AppId || sent["total_num"] != "1" {
+		t.Errorf("request = %v", sent)
+	}
+}
+
+// query responses of payouts are not signed
+func TestPayoutQueries(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	srv.signed = false
+	srv.replies["/mmpaymkttransfers/gethbinfo"] = success(Params{"mch_billno": "B1", "status": RedPackStatusReceived, "total_amount": "100"})
+	srv.replies["/mmpaymkttransfers/gettransferinfo"] = success(Params{"partner_trade_no": "T1", "status": TransferStatusSuccess, "payment_amount": "100"})
+	info, err := client.GetRedPackInfo(context.Background(), "B1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != RedPackStatusReceived || info.TotalAmount != 100 {
+		t.Errorf("red packet = %+v", info)
+	}
+	tinfo, err := client.GetTransferInfo(context.Background(), "T1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tinfo.Status != TransferStatusSuccess || tinfo.PaymentAmount != 100 {
+		t.Errorf("transfer = %+v", tinfo)
+	}
+	// other responses must be signed
+	srv.replies["/mmpaymkttransfers/sendredpack"] = success(Params{"mch_billno": "B1"})
+	if _, err := client.SendRedPack(context.Background(), &RedPack{MchBillno: "B1"}); !Retryable(err) {
+		t.Errorf("unsigned red packet result: %v", err)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	client, srv := newTestClient(t)
+	defer srv.Close()
+	path := "/mmpaymkttransfers/sendredpack"
+	send := func(ctx context.Context, c *Client) error {
+		_, err := c.SendRedPack(ctx, &RedPack{MchBillno: "B1"})
+		return err
+	}
+	ctx := context.Background()
+
+	if err := send(ctx, NewClient("a", "m", testKey)); err == nil || Retryable(err) {
+		t.Errorf("without certificate: %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := send(canceled, client); err == nil || Retryable(err) {
+		t.Errorf("canceled before sending: %v", err)
+	}
+
+	srv.replies[path] = Params{"return_code": CodeFail, "return_msg": "系统繁忙", "err_code": ErrCodeSystemError}
+	if err := send(ctx, client); !Retryable(err) || ErrCode(err) != ErrCodeSystemError {
+		t.Errorf("return_code FAIL: %v", err)
+	}
+	srv.replies[path] = Params{"return_code": CodeSuccess, "result_code": CodeFail, "err_code": ErrCodeNotEnough}
+	if err := send(ctx, client); Retryable(err) || ErrCode(err) != ErrCodeNotEnough {
+		t.Errorf("result_code FAIL: %v", err)
+	}
+
+	// timeouts after sending, the server got the request
+	srv.replies[path] = success(Params{"mch_billno": "B1"})
+	srv.delay = 200 * time.Millisecond
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := send(timeout, client); !Retryable(err) {
+		t.Errorf("ctx timeout: %v", err)
+	}
+	slow := *client
+	slow.TLSClient = &http.Client{Transport: srv.Client().Transport, Timeout: 50 * time.Millisecond}
+	if err := send(ctx, &slow); !Retryable(err) {
+		t.Errorf("client timeout: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package payv2
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// err_code of red packets and transfers
+const (
+	ErrCodeSystemError   = "SYSTEMERROR" // retry with the same bill no
+	ErrCodeProcessing    = "PROCESSING"  // retry with the same bill no
+	ErrCodeFreqLimit     = "FREQ_LIMIT"  // retry later with the same bill no
+	ErrCodeNotEnough     = "NOTENOUGH"
+	ErrCodeSendNumLimit  = "SENDNUM_LIMIT"
+	ErrCodeMoneyLimit    = "MONEY_LIMIT"
+	ErrCodeAmountLimit   = "AMOUNT_LIMIT"
+	ErrCodeOpenIdError   = "OPENID_ERROR"
+	ErrCodeNameMismatch  = "NAME_MISMATCH"
+	ErrCodeSendFailed    = "SEND_FAILED"
+	ErrCodeNotFound      = "NOT_FOUND"
+	ErrCodeParamError    = "PARAM_ERROR"
+	ErrCodeSignError     = "SIGN_ERROR"
+	ErrCodeNoAuth        = "NO_AUTH"
+	ErrCodeCAError       = "CA_ERROR"
+	ErrCodeAccountBanned = "V2_ACCOUNT_SIMPLE_BAN"
+)
+
+// status of RedPackInfo
+const (
+	RedPackStatusSending   = "SENDING"
+	RedPackStatusSent      = "SENT"
+	RedPackStatusFailed    = "FAILED"
+	RedPackStatusReceived  = "RECEIVED"
+	RedPackStatusRefunding = "RFUND_ING"
+	RedPackStatusRefund    = "REFUND"
+)
+
+// status of TransferInfo
+const (
+	TransferStatusSuccess    = "SUCCESS"
+	TransferStatusFailed     = "FAILED"
+	TransferStatusProcessing = "PROCESSING"
+)
+
+// check_name of Transfer
+const (
+	CheckNameNo    = "NO_CHECK"
+	CheckNameForce = "FORCE_CHECK"
+)
+
+// max sequence of BillNo, 10 digits
+const billNoMaxSeq = 10000000000
+
+// red packet to send, amounts in fen (分)
+type RedPack struct {
+	XMLName     xml.Name `xml:"xml"`
+	MchBillno   string   `xml:"mch_billno"` // see BillNo
+	SendName    string   `xml:"send_name"`
+	ReOpenId    string   `xml:"re_openid"`
+	TotalAmount int64    `xml:"total_amount"`
+	TotalNum    int64    `xml:"total_num"` // 1 for SendRedPack, at least 3 for SendGroupRedPack
+	Wishing     string   `xml:"wishing"`
+	ClientIp    string   `xml:"client_ip,omitempty"` // required by SendRedPack
+	ActName     string   `xml:"act_name"`
+	Remark      string   `xml:"remark"`
+	SceneId     string   `xml:"scene_id,omitempty"` // required if amount over 200 yuan
+	RiskInfo    string   `xml:"risk_info,omitempty"`
+}
+
+type RedPackResult struct {
+	Result
+	MchBillno   string `xml:"mch_billno"`
+	WxAppId     string `xml:"wxappid"`
+	ReOpenId    string `xml:"re_openid"`
+	TotalAmount int64  `xml:"total_amount"`
+	SendListId  string `xml:"send_listid"`
+}
+
+type RedPackInfo struct {
+	Result
+	MchBillno    string `xml:"mch_billno"`
+	DetailId     string `xml:"detail_id"`
+	Status       string `xml:"status"`
+	SendType     string `xml:"send_type"`
+	HbType       string `xml:"hb_type"` // GROUP or NORMAL
+	TotalNum     int64  `xml:"total_num"`
+	TotalAmount  int64  `xml:"total_amount"`
+	Reason       string `xml:"reason"`
+	SendTime     string `xml:"send_time"`
+	RefundTime   string `xml:"refund_time"`
+	RefundAmount int64  `xml:"refund_amount"`
+	Wishing      string `xml:"wishing"`
+	Remark       string `xml:"remark"`
+	ActName      string `xml:"act_name"`
+	HbList       []struct {
+		OpenId  string `xml:"openid"`
+		Amount  int64  `xml:"amount"`
+		RcvTime string `xml:"rcv_time"`
+	} `xml:"hblist>hbinfo"`
+}
+
+// payment to openid (企业付款), amount in fen (分)
+type Transfer struct {
+	XMLName        xml.Name `xml:"xml"`
+	DeviceInfo     string   `xml:"device_info,omitempty"`
+	PartnerTradeNo string   `xml:"partner_trade_no"` // see BillNo
+	OpenId         string   `xml:"openid"`
+	CheckName      string   `xml:"check_name"` // CheckNameNo if empty
+	ReUserName     string   `xml:"re_user_name,omitempty"`
+	Amount         int64    `xml:"amount"`
+	Desc           string   `xml:"desc"`
+	SpbillCreateIp string   `xml:"spbill_create_ip"`
+}
+
+type TransferResult struct {
+	Result
+	PartnerTradeNo string `xml:"partner_trade_no"`
+	PaymentNo      string `xml:"payment_no"`
+	PaymentTime    string `xml:"payment_time"`
+}
+
+type TransferInfo struct {
+	Result
+	PartnerTradeNo string `xml:"partner_trade_no"`
+	DetailId       string `xml:"detail_id"`
+	Status         string `xml:"status"`
+	Reason         string `xml:"reason"`
+	OpenId         string `xml:"openid"`
+	TransferName   string `xml:"transfer_name"`
+	PaymentAmount  int64  `xml:"payment_amount"`
+	TransferTime   string `xml:"transfer_time"`
+	PaymentTime    string `xml:"payment_time"`
+	Desc           string `xml:"desc"`
+}
+
+// bill no of red packet or transfer: mch_id, date and 10 digits of seq.
+// store it before sending, and send again with the same bill no
+// when Retryable, so the payout is made only once
+func (this *Client) BillNo(date time.Time, seq int64) (string, error) {
+	if seq < 0 || seq >= billNoMaxSeq {
+		return "", errors.New(fmt.Sprintf("bill no seq %d out of range 0-%d", seq, billNoMaxSeq-1))
+	}
+	return fmt.Sprintf("%s%s%010d", this.MchId, date.Format("20060102"), seq), nil
+}
+
+// err_code of error returned by api, empty if none
+func ErrCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.ErrCode
+	}
+	return ""
+}
+
+// whether the payout may be sent again with the same bill no,
+// true if the request may have reached the server but its result is unknown,
+// false if it failed before sending or was rejected
+func Retryable(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *Error:
+		switch e.ErrCode {
+		case ErrCodeSystemError, ErrCodeProcessing, ErrCodeFreqLimit:
+			return true
+		}
+		return false
+	case *ResponseError:
+		return true
+	}
+	return false
+}
+
+// send red packet to one user, requires merchant certificate
+func (this *Client) SendRedPack(ctx context.Context, rp *RedPack) (*RedPackResult, error) {
+	r := *rp
+	if r.TotalNum == 0 {
+		r.TotalNum = 1
+	}
+	return this.sendRedPack(ctx, "/mmpaymkttransfers/sendredpack", &r, nil)
+}
+
+// send fission red packet shared by TotalNum users, requires merchant certificate
+func (this *Client) SendGroupRedPack(ctx context.Context, rp *RedPack) (*RedPackResult, error) {
+	r := *rp
+	r.ClientIp = ""
+	return this.sendRedPack(ctx, "/mmpaymkttransfers/sendgroupredpack", &r, Params{"amt_type": "ALL_RAND"})
+}
+
+func (this *Client) sendRedPack(ctx context.Context, path string, rp *RedPack, extra Params) (*RedPackResult, error) {
+	params, err := toParams(rp)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	params["wxappid"] = this.AppId
+	params["mch_id"] = this.MchId
+	var rtn RedPackResult
+	if err := this.md5().post(ctx, path, params, &rtn, withCert); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// query red packet by mch_billno, requires merchant certificate
+func (this *Client) GetRedPackInfo(ctx context.Context, mchBillno string) (*RedPackInfo, error) {
+	params := Params{
+		"appid":      this.AppId,
+		"mch_id":     this.MchId,
+		"mch_billno": mchBillno,
+		"bill_type":  "MCHT",
+	}
+	var rtn RedPackInfo
+	if err := this.md5().post(ctx, "/mmpaymkttransfers/gethbinfo", params, &rtn, withCert|skipVerify); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// pay to user's balance, requires merchant certificate
+func (this *Client) Transfers(ctx context.Context, t *Transfer) (*TransferResult, error) {
+	r := *t
+	if r.CheckName == "" {
+		r.CheckName = CheckNameNo
+	}
+	params, err := toParams(&r)
+	if err != nil {
+		return nil, err
+	}
+	params["mch_appid"] = this.AppId
+	params["mchid"] = this.MchId
+	var rtn TransferResult
+	if err := this.md5().post(ctx, "/mmpaymkttransfers/promotion/transfers", params, &rtn, withCert|skipVerify); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// query transfer by partner_trade_no, requires merchant certificate
+func (this *Client) GetTransferInfo(ctx context.Context, partnerTradeNo string) (*TransferInfo, error) {
+	params := Params{
+		"appid":            this.AppId,
+		"mch_id":           this.MchId,
+		"partner_trade_no": partnerTradeNo,
+	}
+	var rtn TransferInfo
+	if err := this.md5().post(ctx, "/mmpaymkttransfers/gettransferinfo", params, &rtn, withCert|skipVerify); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
+
+// red packets and transfers only support MD5 sign
+func (this *Client) md5() *Client {
+	c := *this
+	c.SignType = SignTypeMD5
+	return &c
+}
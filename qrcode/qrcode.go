@@ -0,0 +1,47 @@
+// Package qrcode renders qrcodes created by weixinmp locally as png,
+// without fetching images from the showqrcode host.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sidbusy/weixinmp"
+	goqrcode "github.com/skip2/go-qrcode"
+)
+
+// error correction levels
+type Level int
+
+const (
+	Low     Level = iota // 7% error recovery
+	Medium               // 15% error recovery
+	High                 // 25% error recovery
+	Highest              // 30% error recovery
+)
+
+var levels = map[Level]goqrcode.RecoveryLevel{
+	Low:     goqrcode.Low,
+	Medium:  goqrcode.Medium,
+	High:    goqrcode.High,
+	Highest: goqrcode.Highest,
+}
+
+// render url of qrcode as png of size pixels wide and high
+func Render(qr *weixinmp.QRCode, size int, level Level, w io.Writer) error {
+	return WritePNG(qr.Url, size, level, w)
+}
+
+// write content as qrcode png of size pixels wide and high
+func WritePNG(content string, size int, level Level, w io.Writer) error {
+	l, ok := levels[level]
+	if !ok {
+		return errors.New(fmt.Sprintf("unknown qrcode level %d", level))
+	}
+	q, err := goqrcode.New(content, l)
+	if err != nil {
+		return err
+	}
+	return q.Write(size, w)
+}
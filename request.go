@@ -42,6 +42,9 @@ type Request struct {
 	Precision float64
 }
 
+// handler of request parsed into mp.Request
+type HandlerFunc func(rw http.ResponseWriter, mp *Weixinmp)
+
 // validate request
 func (this *Request) IsValid(rw http.ResponseWriter, req *http.Request) bool {
 	if !this.checkSignature(req) {
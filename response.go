@@ -2,8 +2,8 @@ package weixinmp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -21,11 +21,48 @@ type response struct {
 	Type      string `json:"type"`
 	MediaId   string `json:"media_id"`
 	CreatedAt int64  `json:"created_at"`
+	Url       string `json:"url"`
 	// ticket fields
 	Ticket        string `json:"ticket"`
 	ExpireSeconds int64  `json:"expire_seconds"`
 }
 
+// errcodes
+const (
+	ErrCodeSystemBusy         = -1
+	ErrCodeInvalidCredential  = 40001
+	ErrCodeInvalidAccessToken = 40014
+	ErrCodeAccessTokenExpired = 42001
+	ErrCodeMenuNotExist       = 46003
+)
+
+// error returned by weixinmp api
+type Error struct {
+	ErrCode int64
+	ErrMsg  string
+}
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("%d %s", this.ErrCode, this.ErrMsg)
+}
+
+// errcode of err, 0 if it is not returned by api
+func ErrCode(err error) int64 {
+	if e, ok := err.(*Error); ok {
+		return e.ErrCode
+	}
+	return 0
+}
+
+// worth retrying with a fresh access_token
+func (this *Error) retryable() bool {
+	switch this.ErrCode {
+	case ErrCodeSystemBusy, ErrCodeInvalidCredential, ErrCodeInvalidAccessToken, ErrCodeAccessTokenExpired:
+		return true
+	}
+	return false
+}
+
 func post(url string, bodyType string, body *bytes.Buffer) (*response, error) {
 	resp, err := http.Post(url, bodyType, body)
 	if err != nil {
@@ -41,7 +78,7 @@ func post(url string, bodyType string, body *bytes.Buffer) (*response, error) {
 		return nil, err
 	}
 	if rtn.ErrCode != 0 {
-		return nil, errors.New(fmt.Sprintf("%d %s", rtn.ErrCode, rtn.ErrMsg))
+		return nil, &Error{rtn.ErrCode, rtn.ErrMsg}
 	}
 	return &rtn, nil
 }
@@ -61,7 +98,59 @@ func get(url string) (*response, error) {
 		return nil, err
 	}
 	if rtn.ErrCode != 0 {
-		return nil, errors.New(fmt.Sprintf("%d %s", rtn.ErrCode, rtn.ErrMsg))
+		return nil, &Error{rtn.ErrCode, rtn.ErrMsg}
 	}
 	return &rtn, nil
 }
+
+// get json from url and unmarshal it into v
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(ctx, req, v)
+}
+
+// post data as json to url and unmarshal the response into v
+func postJSON(ctx context.Context, url string, data interface{}, v interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return postData(ctx, url, "application/json; charset=utf-8", body, v)
+}
+
+// post body to url and unmarshal the response into v
+func postData(ctx context.Context, url string, bodyType string, body []byte, v interface{}) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", bodyType)
+	return doJSON(ctx, req, v)
+}
+
+func doJSON(ctx context.Context, req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// has error?
+	var rtn response
+	if err := json.Unmarshal(data, &rtn); err != nil {
+		return err
+	}
+	if rtn.ErrCode != 0 {
+		return &Error{rtn.ErrCode, rtn.ErrMsg}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,105 @@
+package weixinmp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// prefix of EventKey of subscribe event from parametric qrcode
+const qrScenePrefix = "qrscene_"
+
+// scene of parametric qrcode scanned by user
+type Scene struct {
+	Id        int64  // integer scene, 0 for string scene
+	Str       string // string scene, or integer scene in decimal
+	Subscribe bool   // subscribed by scanning, or scanned by subscribed user
+}
+
+// parse scene of subscribe or SCAN event, false if not from parametric qrcode
+func (this *Request) Scene() (Scene, bool) {
+	var sc Scene
+	if this.MsgType != MsgTypeEvent {
+		return sc, false
+	}
+	key := this.EventKey
+	switch this.Event {
+	case EventSubscribe:
+		if !strings.HasPrefix(key, qrScenePrefix) {
+			return sc, false
+		}
+		key = key[len(qrScenePrefix):]
+		sc.Subscribe = true
+	case EventScan:
+	default:
+		return sc, false
+	}
+	if key == "" {
+		return sc, false
+	}
+	sc.Str = key
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		sc.Id = id
+	}
+	return sc, true
+}
+
+// route subscribe and SCAN events to handlers by scene,
+// handlers get the scene by mp.Request.Scene()
+type SceneRouter struct {
+	mu       sync.RWMutex
+	ids      map[int64]HandlerFunc
+	strs     map[string]HandlerFunc
+	fallback HandlerFunc
+}
+
+// handle scans of string scene
+func (this *SceneRouter) OnScene(sceneStr string, h HandlerFunc) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.strs == nil {
+		this.strs = make(map[string]HandlerFunc)
+	}
+	this.strs[sceneStr] = h
+}
+
+// handle scans of integer scene, including string scenes of the same number,
+// e.g. "007" matches 7, as Scene parses every numeric key as an id
+func (this *SceneRouter) OnSceneId(sceneId int64, h HandlerFunc) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if this.ids == nil {
+		this.ids = make(map[int64]HandlerFunc)
+	}
+	this.ids[sceneId] = h
+}
+
+// handle scans of scenes without handler
+func (this *SceneRouter) OnAnyScene(h HandlerFunc) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.fallback = h
+}
+
+// call handler of the scene, returns false if none
+func (this *SceneRouter) Dispatch(rw http.ResponseWriter, mp *Weixinmp) bool {
+	sc, ok := mp.Request.Scene()
+	if !ok {
+		return false
+	}
+	this.mu.RLock()
+	h, ok := this.strs[sc.Str]
+	if !ok && sc.Id != 0 {
+		h, ok = this.ids[sc.Id]
+	}
+	if !ok && this.fallback != nil {
+		h, ok = this.fallback, true
+	}
+	this.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	h(rw, mp)
+	return true
+}
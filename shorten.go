@@ -0,0 +1,72 @@
+package weixinmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// limits of shorten/gen
+const (
+	shortMaxLongData      = 4096
+	shortMaxExpireSeconds = 2592000 // 30 days
+)
+
+// data stored by shorten/gen
+type ShortKeyInfo struct {
+	LongData      string `json:"long_data"`
+	CreateTime    int64  `json:"create_time"`
+	ExpireSeconds int64  `json:"expire_seconds"`
+}
+
+// convert long url to short url
+func (this *Weixinmp) ShortenURL(longURL string) (string, error) {
+	var req struct {
+		Action  string `json:"action"`
+		LongUrl string `json:"long_url"`
+	}
+	req.Action = "long2short"
+	req.LongUrl = longURL
+	var rtn struct {
+		ShortUrl string `json:"short_url"`
+	}
+	if err := this.postAPI("shorturl", &req, &rtn); err != nil {
+		return "", err
+	}
+	return rtn.ShortUrl, nil
+}
+
+// store long data and returns short key, expireSeconds is 30 days if zero
+func (this *Weixinmp) GenShortKey(longData string, expireSeconds int64) (string, error) {
+	if len(longData) == 0 || len(longData) > shortMaxLongData {
+		return "", errors.New(fmt.Sprintf("long data length %d out of range 1-%d", len(longData), shortMaxLongData))
+	}
+	if expireSeconds < 0 || expireSeconds > shortMaxExpireSeconds {
+		return "", errors.New(fmt.Sprintf("expire seconds %d out of range 0-%d", expireSeconds, shortMaxExpireSeconds))
+	}
+	var req struct {
+		LongData      string `json:"long_data"`
+		ExpireSeconds int64  `json:"expire_seconds,omitempty"`
+	}
+	req.LongData = longData
+	req.ExpireSeconds = expireSeconds
+	var rtn struct {
+		ShortKey string `json:"short_key"`
+	}
+	if err := this.postAPI("shorten/gen", &req, &rtn); err != nil {
+		return "", err
+	}
+	return rtn.ShortKey, nil
+}
+
+// get long data of short key
+func (this *Weixinmp) FetchShortKey(shortKey string) (*ShortKeyInfo, error) {
+	var req struct {
+		ShortKey string `json:"short_key"`
+	}
+	req.ShortKey = shortKey
+	var rtn ShortKeyInfo
+	if err := this.postAPI("shorten/fetch", &req, &rtn); err != nil {
+		return nil, err
+	}
+	return &rtn, nil
+}
@@ -0,0 +1,120 @@
+package weixinmp
+
+import (
+	"context"
+	"fmt"
+)
+
+const batchTagSize = 50 // openids per batch tagging call
+
+type Tag struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count,omitempty"`
+}
+
+// create tag
+func (this *Weixinmp) CreateTag(name string) (Tag, error) {
+	var req, rtn struct {
+		Tag Tag `json:"tag"`
+	}
+	req.Tag.Name = name
+	err := this.postAPI("tags/create", &req, &rtn)
+	return rtn.Tag, err
+}
+
+// get all tags
+func (this *Weixinmp) GetTags() ([]Tag, error) {
+	var rtn struct {
+		Tags []Tag `json:"tags"`
+	}
+	err := this.getAPI("tags/get", nil, &rtn)
+	return rtn.Tags, err
+}
+
+// rename tag
+func (this *Weixinmp) UpdateTag(tagId int64, name string) error {
+	var req struct {
+		Tag Tag `json:"tag"`
+	}
+	req.Tag.Id = tagId
+	req.Tag.Name = name
+	return this.postAPI("tags/update", &req, nil)
+}
+
+// delete tag
+func (this *Weixinmp) DeleteTag(tagId int64) error {
+	var req struct {
+		Tag struct {
+			Id int64 `json:"id"`
+		} `json:"tag"`
+	}
+	req.Tag.Id = tagId
+	return this.postAPI("tags/delete", &req, nil)
+}
+
+// tag users, 50 openids per call
+func (this *Weixinmp) BatchTagUsers(tagId int64, openIds []string) error {
+	return this.batchTagging("tags/members/batchtagging", tagId, openIds)
+}
+
+// untag users, 50 openids per call
+func (this *Weixinmp) BatchUntagUsers(tagId int64, openIds []string) error {
+	return this.batchTagging("tags/members/batchuntagging", tagId, openIds)
+}
+
+func (this *Weixinmp) batchTagging(api string, tagId int64, openIds []string) error {
+	var req struct {
+		OpenIdList []string `json:"openid_list"`
+		TagId      int64    `json:"tagid"`
+	}
+	req.TagId = tagId
+	for i := 0; i < len(openIds); i += batchTagSize {
+		j := i + batchTagSize
+		if j > len(openIds) {
+			j = len(openIds)
+		}
+		req.OpenIdList = openIds[i:j]
+		if err := this.postAPI(api, &req, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get tag ids of user
+func (this *Weixinmp) GetUserTags(openId string) ([]int64, error) {
+	var req struct {
+		OpenId string `json:"openid"`
+	}
+	req.OpenId = openId
+	var rtn struct {
+		TagIdList []int64 `json:"tagid_list"`
+	}
+	err := this.postAPI("tags/getidlist", &req, &rtn)
+	return rtn.TagIdList, err
+}
+
+// list users under tag, starting after nextOpenId (empty for the beginning)
+func (this *Weixinmp) ListTagUsers(tagId int64, nextOpenId string) *UserIterator {
+	return &UserIterator{
+		next: nextOpenId,
+		fetch: func(ctx context.Context, next string) (*userList, error) {
+			var req struct {
+				TagId      int64  `json:"tagid"`
+				NextOpenId string `json:"next_openid"`
+			}
+			req.TagId = tagId
+			req.NextOpenId = next
+			var list userList
+			url := fmt.Sprintf("%suser/tag/get?access_token=", UrlPrefix)
+			err := this.call(ctx, func(token string) error {
+				return postJSON(ctx, url+token, &req, &list)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &list, nil
+		},
+	}
+}
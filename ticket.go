@@ -0,0 +1,133 @@
+package weixinmp
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jsapi_ticket for js-sdk, cached like access_token
+type JSTicket struct {
+	AccessToken *AccessToken
+	TmpName     string
+	LckName     string
+}
+
+// get fresh jsapi_ticket string
+func (this *JSTicket) Fresh() (string, error) {
+	if this.AccessToken == nil {
+		return "", errors.New("JSTicket access token is not set")
+	}
+	if this.TmpName == "" {
+		this.TmpName = this.AccessToken.AppId + "-jsapiticket.tmp"
+	}
+	if this.LckName == "" {
+		this.LckName = this.TmpName + ".lck"
+	}
+	return freshTmp(this.TmpName, this.LckName, func() (string, error) {
+		return fetchTicket(this.AccessToken, "jsapi")
+	})
+}
+
+// jsapi_ticket using access_token of mp, also when mp is not created by New
+func (this *Weixinmp) jsTicket() *JSTicket {
+	if this.JSTicket.AccessToken == nil {
+		this.JSTicket.AccessToken = &this.AccessToken
+	}
+	return &this.JSTicket
+}
+
+// get ticket of type with fresh access_token, retry on failure
+func fetchTicket(accessToken *AccessToken, typ string) (string, error) {
+	url := fmt.Sprintf("%sticket/getticket?type=%s&access_token=", UrlPrefix, typ)
+	ticket := ""
+	// retry
+	for i := 0; i < retryNum; i++ {
+		token, err := accessToken.Fresh()
+		if err != nil {
+			if i < retryNum-1 {
+				continue
+			}
+			return "", err
+		}
+		rtn, err := get(url + token)
+		if err != nil {
+			if i < retryNum-1 {
+				continue
+			}
+			return "", err
+		}
+		ticket = rtn.Ticket
+		break // success
+	}
+	return ticket, nil
+}
+
+// config for wx.config
+type JSConfig struct {
+	AppId     string `json:"appId"`
+	Timestamp int64  `json:"timestamp"`
+	NonceStr  string `json:"nonceStr"`
+	Signature string `json:"signature"`
+}
+
+// sign wx.config for the page at url
+func (this *Weixinmp) SignJSConfig(url string) (*JSConfig, error) {
+	ticket, err := this.jsTicket().Fresh()
+	if err != nil {
+		return nil, err
+	}
+	nonceStr, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	// without fragment
+	if i := strings.Index(url, "#"); i >= 0 {
+		url = url[:i]
+	}
+	cfg := &JSConfig{
+		AppId:     this.AccessToken.AppId,
+		Timestamp: time.Now().Unix(),
+		NonceStr:  nonceStr,
+	}
+	s := fmt.Sprintf(
+		"jsapi_ticket=%s&noncestr=%s&timestamp=%d&url=%s",
+		ticket,
+		cfg.NonceStr,
+		cfg.Timestamp,
+		url,
+	)
+	cfg.Signature = fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+	return cfg, nil
+}
+
+// serve wx.config as json for the page in "url" parameter, or the referer
+func (this *Weixinmp) JSConfigHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		url := req.FormValue("url")
+		if url == "" {
+			url = req.Referer()
+		}
+		if url == "" {
+			http.Error(rw, "missing url", http.StatusBadRequest)
+			return
+		}
+		cfg, err := this.SignJSConfig(url)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Write(data)
+	})
+}
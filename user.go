@@ -0,0 +1,240 @@
+package weixinmp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+const (
+	batchUserInfoSize    = 100 // openids per user/info/batchget call
+	batchUserInfoWorkers = 4   // concurrent user/info/batchget calls
+	batchBlacklistSize   = 20  // openids per batch blacklist call
+)
+
+// page of user openids
+type userList struct {
+	Total int64 `json:"total"`
+	Count int64 `json:"count"`
+	Data  struct {
+		OpenId []string `json:"openid"`
+	} `json:"data"`
+	NextOpenId string `json:"next_openid"`
+}
+
+// iterate user openids page by page, up to 10000 per page
+type UserIterator struct {
+	fetch   func(ctx context.Context, nextOpenId string) (*userList, error)
+	next    string
+	total   int64
+	openIds []string
+	done    bool
+	err     error
+}
+
+// fetch next page, returns false when finished or failed
+func (this *UserIterator) Next(ctx context.Context) bool {
+	if this.done || this.err != nil {
+		return false
+	}
+	list, err := this.fetch(ctx, this.next)
+	if err != nil {
+		this.err = err
+		return false
+	}
+	this.total = list.Total
+	if list.Count == 0 || len(list.Data.OpenId) == 0 {
+		this.openIds = nil
+		this.done = true
+		return false
+	}
+	this.openIds = list.Data.OpenId
+	this.next = list.NextOpenId
+	if this.next == "" {
+		this.done = true
+	}
+	return true
+}
+
+// openids of current page
+func (this *UserIterator) OpenIds() []string {
+	return this.openIds
+}
+
+// total number of users, if reported by the api
+func (this *UserIterator) Total() int64 {
+	return this.total
+}
+
+// cursor for resuming after current page
+func (this *UserIterator) NextOpenId() string {
+	return this.next
+}
+
+// error stopped the iteration
+func (this *UserIterator) Err() error {
+	return this.err
+}
+
+// list subscribed users, starting after nextOpenId (empty for the beginning)
+func (this *Weixinmp) ListUsers(nextOpenId string) *UserIterator {
+	return &UserIterator{
+		next: nextOpenId,
+		fetch: func(ctx context.Context, next string) (*userList, error) {
+			var list userList
+			u := fmt.Sprintf("%suser/get?next_openid=%s&access_token=", UrlPrefix, url.QueryEscape(next))
+			err := this.call(ctx, func(token string) error {
+				return getJSON(ctx, u+token, &list)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &list, nil
+		},
+	}
+}
+
+// get user info of many users, in the order of openIds
+// lang overrides the default language if not empty
+func (this *Weixinmp) BatchGetUserInfo(openIds []string, lang Lang) ([]UserInfo, error) {
+	lang = this.lang(lang)
+	// fetch token once, so the workers share it instead of fetching concurrently
+	if len(openIds) > 0 {
+		if _, err := this.AccessToken.Fresh(); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	uinfs := make([]UserInfo, len(openIds))
+	sem := make(chan struct{}, batchUserInfoWorkers)
+	errc := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < len(openIds); i += batchUserInfoSize {
+		j := i + batchUserInfoSize
+		if j > len(openIds) {
+			j = len(openIds)
+		}
+		wg.Add(1)
+		go func(i, j int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return
+			}
+			if err := this.batchGetUserInfo(ctx, openIds[i:j], lang, uinfs[i:j]); err != nil {
+				select {
+				case errc <- err:
+				default:
+				}
+				cancel()
+			}
+		}(i, j)
+	}
+	wg.Wait()
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	return uinfs, nil
+}
+
+// get user info of at most 100 users into uinfs
+func (this *Weixinmp) batchGetUserInfo(ctx context.Context, openIds []string, lang Lang, uinfs []UserInfo) error {
+	type user struct {
+		Openid string `json:"openid"`
+		Lang   Lang   `json:"lang"`
+	}
+	var req struct {
+		UserList []user `json:"user_list"`
+	}
+	for _, openId := range openIds {
+		req.UserList = append(req.UserList, user{Openid: openId, Lang: lang})
+	}
+	var rtn struct {
+		UserInfoList []UserInfo `json:"user_info_list"`
+	}
+	u := fmt.Sprintf("%suser/info/batchget?access_token=", UrlPrefix)
+	err := this.call(ctx, func(token string) error {
+		return postJSON(ctx, u+token, &req, &rtn)
+	})
+	if err != nil {
+		return err
+	}
+	// merge by openid
+	m := make(map[string]UserInfo, len(rtn.UserInfoList))
+	for _, uinf := range rtn.UserInfoList {
+		m[uinf.Openid] = uinf
+	}
+	for k, openId := range openIds {
+		uinf, ok := m[openId]
+		if !ok {
+			uinf.Openid = openId
+		}
+		uinfs[k] = uinf
+	}
+	return nil
+}
+
+// set remark name of user
+func (this *Weixinmp) UpdateUserRemark(openId, remark string) error {
+	var req struct {
+		OpenId string `json:"openid"`
+		Remark string `json:"remark"`
+	}
+	req.OpenId = openId
+	req.Remark = remark
+	return this.postAPI("user/info/updateremark", &req, nil)
+}
+
+// list blacklisted users, starting after beginOpenId (empty for the beginning)
+func (this *Weixinmp) ListBlacklist(beginOpenId string) *UserIterator {
+	return &UserIterator{
+		next: beginOpenId,
+		fetch: func(ctx context.Context, next string) (*userList, error) {
+			var req struct {
+				BeginOpenId string `json:"begin_openid"`
+			}
+			req.BeginOpenId = next
+			var list userList
+			u := fmt.Sprintf("%stags/members/getblacklist?access_token=", UrlPrefix)
+			err := this.call(ctx, func(token string) error {
+				return postJSON(ctx, u+token, &req, &list)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return &list, nil
+		},
+	}
+}
+
+// blacklist users, 20 openids per call
+func (this *Weixinmp) BatchBlacklist(openIds []string) error {
+	return this.batchBlacklist("tags/members/batchblacklist", openIds)
+}
+
+// remove users from blacklist, 20 openids per call
+func (this *Weixinmp) BatchUnblacklist(openIds []string) error {
+	return this.batchBlacklist("tags/members/batchunblacklist", openIds)
+}
+
+func (this *Weixinmp) batchBlacklist(api string, openIds []string) error {
+	var req struct {
+		OpenIdList []string `json:"openid_list"`
+	}
+	for i := 0; i < len(openIds); i += batchBlacklistSize {
+		j := i + batchBlacklistSize
+		if j > len(openIds) {
+			j = len(openIds)
+		}
+		req.OpenIdList = openIds[i:j]
+		if err := this.postAPI(api, &req, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
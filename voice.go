@@ -0,0 +1,90 @@
+package weixinmp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// decoder of speex or amr voice, implemented outside this package
+type VoiceDecoder interface {
+	// decode voice into 16-bit mono pcm samples
+	Decode(r io.Reader) (pcm []int16, sampleRate int, err error)
+}
+
+// download high definition voice uploaded by js-sdk, in speex format
+func (this *Weixinmp) DownloadHDVoice(ctx context.Context, mediaId string, w io.Writer) (string, string, error) {
+	url := fmt.Sprintf("%smedia/get/jssdk?media_id=%s&access_token=", UrlPrefix, mediaId)
+	return this.download(ctx, "GET", url, nil, w)
+}
+
+// download high definition voice to file
+func (this *Weixinmp) DownloadHDVoiceFile(mediaId, fileName string) error {
+	return writeFileAtomic(fileName, func(w io.Writer) error {
+		_, _, err := this.DownloadHDVoice(context.Background(), mediaId, w)
+		return err
+	})
+}
+
+// download voice, speex if hd or amr otherwise, and write it to w as wav
+func (this *Weixinmp) DownloadVoiceWAV(ctx context.Context, mediaId string, hd bool, dec VoiceDecoder, w io.Writer) error {
+	var buf bytes.Buffer
+	var err error
+	if hd {
+		_, _, err = this.DownloadHDVoice(ctx, mediaId, &buf)
+	} else {
+		_, _, err = this.DownloadMedia(ctx, mediaId, &buf)
+	}
+	if err != nil {
+		return err
+	}
+	pcm, sampleRate, err := dec.Decode(&buf)
+	if err != nil {
+		return err
+	}
+	return WriteWAV(w, pcm, sampleRate)
+}
+
+// write 16-bit mono pcm samples as wav
+func WriteWAV(w io.Writer, pcm []int16, sampleRate int) error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	dataSize := uint32(len(pcm) * 2)
+	header := struct {
+		ChunkId       [4]byte
+		ChunkSize     uint32
+		Format        [4]byte
+		Subchunk1Id   [4]byte
+		Subchunk1Size uint32
+		AudioFormat   uint16
+		NumChannels   uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Subchunk2Id   [4]byte
+		Subchunk2Size uint32
+	}{
+		ChunkId:       [4]byte{'R', 'I', 'F', 'F'},
+		ChunkSize:     36 + dataSize,
+		Format:        [4]byte{'W', 'A', 'V', 'E'},
+		Subchunk1Id:   [4]byte{'f', 'm', 't', ' '},
+		Subchunk1Size: 16,
+		AudioFormat:   1, // pcm
+		NumChannels:   channels,
+		SampleRate:    uint32(sampleRate),
+		ByteRate:      uint32(sampleRate * channels * bitsPerSample / 8),
+		BlockAlign:    channels * bitsPerSample / 8,
+		BitsPerSample: bitsPerSample,
+		Subchunk2Id:   [4]byte{'d', 'a', 't', 'a'},
+		Subchunk2Size: dataSize,
+	}
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, pcm)
+}
@@ -2,15 +2,17 @@ package weixinmp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
+	"math"
 	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
 	"reflect"
 	"time"
 )
@@ -32,30 +34,136 @@ const (
 	EventLocation    = "LOCATION"
 	EventClick       = "CLICK"
 	EventView        = "VIEW"
+	// menu event types
+	EventScancodePush    = "scancode_push"
+	EventScancodeWaitmsg = "scancode_waitmsg"
+	EventPicSysphoto     = "pic_sysphoto"
+	EventPicPhotoOrAlbum = "pic_photo_or_album"
+	EventPicWeixin       = "pic_weixin"
+	EventLocationSelect  = "location_select"
+	EventViewMiniprogram = "view_miniprogram"
 	// media types
 	MediaTypeImage = "image"
 	MediaTypeVoice = "voice"
 	MediaTypeVideo = "video"
 	MediaTypeThumb = "thumb"
 	// button types
-	ButtonTypeClick = "click"
-	ButtonTypeView  = "view"
+	ButtonTypeClick              = "click"
+	ButtonTypeView               = "view"
+	ButtonTypeScancodePush       = "scancode_push"
+	ButtonTypeScancodeWaitmsg    = "scancode_waitmsg"
+	ButtonTypePicSysphoto        = "pic_sysphoto"
+	ButtonTypePicPhotoOrAlbum    = "pic_photo_or_album"
+	ButtonTypePicWeixin          = "pic_weixin"
+	ButtonTypeLocationSelect     = "location_select"
+	ButtonTypeMediaId            = "media_id"
+	ButtonTypeViewLimited        = "view_limited"
+	ButtonTypeArticleId          = "article_id"
+	ButtonTypeArticleViewLimited = "article_view_limited"
+	ButtonTypeMiniprogram        = "miniprogram"
 	// environment constants
 	UrlPrefix      = "https://api.weixin.qq.com/cgi-bin/"
 	MediaUrlPrefix = "http://file.api.weixin.qq.com/cgi-bin/media/"
 	retryNum       = 3
+	// qrcode limits
+	qrMaxExpireSeconds = 2592000 // 30 days
+	qrMaxLimitSceneId  = 100000
+	qrMaxSceneStrLen   = 64
+)
+
+// languages of user info
+type Lang string
+
+const (
+	LangZhCN Lang = "zh_CN" // 简体
+	LangZhTW Lang = "zh_TW" // 繁体
+	LangEn   Lang = "en"    // 英语
 )
 
 type Weixinmp struct {
 	Request     Request
 	AccessToken AccessToken
+	JSTicket    JSTicket
+	CardTicket  CardTicket
+	OAuth       OAuth
+	Lang        Lang // default language, zh_CN if empty
 }
 
 func New(token, appId, appSecret string) *Weixinmp {
-	return &Weixinmp{
+	mp := &Weixinmp{
 		Request:     Request{Token: token},
 		AccessToken: AccessToken{AppId: appId, AppSecret: appSecret},
+		OAuth:       OAuth{AppId: appId, AppSecret: appSecret},
+	}
+	mp.JSTicket.AccessToken = &mp.AccessToken
+	mp.CardTicket.AccessToken = &mp.AccessToken
+	return mp
+}
+
+// language of a call, the first non-empty one of lang, this.Lang and zh_CN
+func (this *Weixinmp) lang(lang ...Lang) Lang {
+	return firstLang(append(lang, this.Lang)...)
+}
+
+// the first non-empty language, zh_CN if none
+func firstLang(lang ...Lang) Lang {
+	for _, l := range lang {
+		if l != "" {
+			return l
+		}
+	}
+	return LangZhCN
+}
+
+// call api with fresh access_token, retry on failure
+func (this *Weixinmp) call(ctx context.Context, fn func(token string) error) error {
+	var err error
+	for i := 0; i < retryNum; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var token string
+		if token, err = this.AccessToken.Fresh(); err != nil {
+			continue
+		}
+		if err = fn(token); err == nil {
+			return nil // success
+		}
+		if e, ok := err.(noRetry); ok {
+			return e.error
+		}
+		if e, ok := err.(*Error); ok && !e.retryable() {
+			return e
+		}
+	}
+	return err
+}
+
+// error not worth retrying
+type noRetry struct {
+	error
+}
+
+// get api under UrlPrefix, params may be nil
+func (this *Weixinmp) getAPI(api string, params url.Values, rtn interface{}) error {
+	ctx := context.Background()
+	url := UrlPrefix + api + "?"
+	if len(params) > 0 {
+		url += params.Encode() + "&"
 	}
+	url += "access_token="
+	return this.call(ctx, func(token string) error {
+		return getJSON(ctx, url+token, rtn)
+	})
+}
+
+// post json to api under UrlPrefix
+func (this *Weixinmp) postAPI(api string, req, rtn interface{}) error {
+	ctx := context.Background()
+	url := fmt.Sprintf("%s%s?access_token=", UrlPrefix, api)
+	return this.call(ctx, func(token string) error {
+		return postJSON(ctx, url+token, req, rtn)
+	})
 }
 
 // message structs
@@ -336,166 +444,112 @@ type qrScene struct {
 	ActionName    string `json:"action_name"`
 	ActionInfo    struct {
 		Scene struct {
-			SceneId int64 `json:"scene_id"`
+			SceneId  int64  `json:"scene_id,omitempty"`
+			SceneStr string `json:"scene_str,omitempty"`
 		} `json:"scene"`
 	} `json:"action_info"`
 }
 
+// created qrcode
+type QRCode struct {
+	Ticket        string `json:"ticket"`
+	ExpireSeconds int64  `json:"expire_seconds"`
+	Url           string `json:"url"` // content of the qrcode image
+}
+
 // get qrcode url
 func (this *Weixinmp) GetQRCodeURL(ticket string) string {
-	return "https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=" + ticket
+	return "https://mp.weixin.qq.com/cgi-bin/showqrcode?ticket=" + url.QueryEscape(ticket)
+}
+
+// download qrcode image (jpg) of ticket to w
+func (this *Weixinmp) DownloadQRCode(ticket string, w io.Writer) error {
+	_, _, err := fetchMedia(context.Background(), "GET", this.GetQRCodeURL(ticket), nil, w)
+	return err
 }
 
-// create permanent qrcode
-func (this *Weixinmp) CreateQRScene(sceneId int64) (string, error) {
+// create temporary qrcode, expires in expireSeconds (30 if zero, 2592000 at most)
+func (this *Weixinmp) CreateQRScene(expireSeconds, sceneId int64) (*QRCode, error) {
+	if sceneId <= 0 || sceneId > math.MaxUint32 {
+		return nil, errors.New(fmt.Sprintf("scene id %d out of range 1-%d", sceneId, uint32(math.MaxUint32)))
+	}
 	var inf qrScene
 	inf.ActionName = "QR_SCENE"
 	inf.ActionInfo.Scene.SceneId = sceneId
-	return this.createQRCode(&inf)
+	return this.createTempQRCode(expireSeconds, &inf)
 }
 
-// create temporary qrcode
-func (this *Weixinmp) CreateQRLimitScene(expireSeconds, sceneId int64) (string, error) {
+// create temporary qrcode with string scene
+func (this *Weixinmp) CreateQRStrScene(expireSeconds int64, sceneStr string) (*QRCode, error) {
+	if err := checkSceneStr(sceneStr); err != nil {
+		return nil, err
+	}
+	var inf qrScene
+	inf.ActionName = "QR_STR_SCENE"
+	inf.ActionInfo.Scene.SceneStr = sceneStr
+	return this.createTempQRCode(expireSeconds, &inf)
+}
+
+// create permanent qrcode, sceneId is 1-100000
+func (this *Weixinmp) CreateQRLimitScene(sceneId int64) (*QRCode, error) {
+	if sceneId <= 0 || sceneId > qrMaxLimitSceneId {
+		return nil, errors.New(fmt.Sprintf("scene id %d out of range 1-%d", sceneId, qrMaxLimitSceneId))
+	}
 	var inf qrScene
-	inf.ExpireSeconds = expireSeconds
 	inf.ActionName = "QR_LIMIT_SCENE"
 	inf.ActionInfo.Scene.SceneId = sceneId
 	return this.createQRCode(&inf)
 }
 
-func (this *Weixinmp) createQRCode(inf *qrScene) (string, error) {
-	data, err := json.Marshal(inf)
-	if err != nil {
-		return "", err
+// create permanent qrcode with string scene
+func (this *Weixinmp) CreateQRLimitStrScene(sceneStr string) (*QRCode, error) {
+	if err := checkSceneStr(sceneStr); err != nil {
+		return nil, err
 	}
-	url := fmt.Sprintf("%sqrcode/create?access_token=", UrlPrefix)
-	buf := bytes.NewBuffer(data)
-	ticket := ""
-	// retry
-	for i := 0; i < retryNum; i++ {
-		token, err := this.AccessToken.Fresh()
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return "", err
-		}
-		rtn, err := post(url+token, "text/plain", buf)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return "", err
-		}
-		ticket = rtn.Ticket
-		break // success
+	var inf qrScene
+	inf.ActionName = "QR_LIMIT_STR_SCENE"
+	inf.ActionInfo.Scene.SceneStr = sceneStr
+	return this.createQRCode(&inf)
+}
+
+func (this *Weixinmp) createTempQRCode(expireSeconds int64, inf *qrScene) (*QRCode, error) {
+	if expireSeconds < 0 || expireSeconds > qrMaxExpireSeconds {
+		return nil, errors.New(fmt.Sprintf("expire seconds %d out of range 0-%d", expireSeconds, qrMaxExpireSeconds))
 	}
-	return ticket, nil
+	inf.ExpireSeconds = expireSeconds
+	return this.createQRCode(inf)
 }
 
-// download media to file
-func (this *Weixinmp) DownloadMediaFile(mediaId, fileName string) error {
-	url := fmt.Sprintf("%sget?media_id=%s&access_token=", MediaUrlPrefix, mediaId)
-	// retry
-	for i := 0; i < retryNum; i++ {
-		token, err := this.AccessToken.Fresh()
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return err
-		}
-		resp, err := http.Get(url + token)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return err
-		}
-		defer resp.Body.Close()
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return err
-		}
-		// json
-		if resp.Header.Get("Content-Type") == "text/plain" {
-			var rtn response
-			if err := json.Unmarshal(data, &rtn); err != nil {
-				if i < retryNum-1 {
-					continue
-				}
-				return err
-			}
-			if i < retryNum-1 {
-				continue
-			}
-			return errors.New(fmt.Sprintf("%d %s", rtn.ErrCode, rtn.ErrMsg))
-		}
-		// media
-		f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, os.ModePerm)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return err
-		}
-		defer f.Close()
-		if _, err := f.Write(data); err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return err
-		}
-		break // success
+func (this *Weixinmp) createQRCode(inf *qrScene) (*QRCode, error) {
+	var qr QRCode
+	if err := this.postAPI("qrcode/create", inf, &qr); err != nil {
+		return nil, err
+	}
+	return &qr, nil
+}
+
+func checkSceneStr(sceneStr string) error {
+	if len(sceneStr) == 0 || len(sceneStr) > qrMaxSceneStrLen {
+		return errors.New(fmt.Sprintf("scene str length %d out of range 1-%d", len(sceneStr), qrMaxSceneStrLen))
 	}
 	return nil
 }
 
-// upload media to file
+// download media to file
+func (this *Weixinmp) DownloadMediaFile(mediaId, fileName string) error {
+	return writeFileAtomic(fileName, func(w io.Writer) error {
+		_, _, err := this.DownloadMedia(context.Background(), mediaId, w)
+		return err
+	})
+}
+
+// upload media from file
 func (this *Weixinmp) UploadMediaFile(mediaType, fileName string) (string, error) {
-	var buf bytes.Buffer
-	bw := multipart.NewWriter(&buf)
-	defer bw.Close()
-	f, err := os.Open(fileName)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-	fw, err := bw.CreateFormFile("filename", f.Name())
+	rtn, err := this.uploadMedia(context.Background(), mediaType, filepath.Base(fileName), "", fileOpener(fileName))
 	if err != nil {
 		return "", err
 	}
-	if _, err := io.Copy(fw, f); err != nil {
-		return "", err
-	}
-	f.Close()
-	bw.Close()
-	url := fmt.Sprintf("%supload?type=%s&access_token=", MediaUrlPrefix, mediaType)
-	mime := bw.FormDataContentType()
-	mediaId := ""
-	// retry
-	for i := 0; i < retryNum; i++ {
-		token, err := this.AccessToken.Fresh()
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return "", err
-		}
-		rtn, err := post(url+token, mime, &buf)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return "", err
-		}
-		mediaId = rtn.MediaId
-		break // success
-	}
-	return mediaId, nil
+	return rtn.MediaId, nil
 }
 
 type Button struct {
@@ -503,11 +557,18 @@ type Button struct {
 	Name      string   `json:"name"`
 	Key       string   `json:"key,omitempty"`
 	Url       string   `json:"url,omitempty"`
+	MediaId   string   `json:"media_id,omitempty"`
+	AppId     string   `json:"appid,omitempty"`
+	PagePath  string   `json:"pagepath,omitempty"`
+	ArticleId string   `json:"article_id,omitempty"`
 	SubButton []Button `json:"sub_button,omitempty"`
 }
 
 // create custom menu
 func (this *Weixinmp) CreateCustomMenu(btn *[]Button) error {
+	if err := ValidateMenu(*btn); err != nil {
+		return err
+	}
 	var menu struct {
 		Button *[]Button `json:"button"`
 	}
@@ -538,63 +599,18 @@ func (this *Weixinmp) CreateCustomMenu(btn *[]Button) error {
 	return nil
 }
 
-// get custom menu
-func (this *Weixinmp) GetCustomMenu() ([]Button, error) {
+// get custom menu, with conditional menus
+func (this *Weixinmp) GetCustomMenu() ([]Button, []ConditionalMenu, error) {
 	var menu struct {
 		Menu struct {
 			Button []Button `json:"button"`
 		} `json:"menu"`
+		ConditionalMenu []ConditionalMenu `json:"conditionalmenu"`
 	}
-	url := fmt.Sprintf("%smenu/get?access_token=", UrlPrefix)
-	// retry
-	for i := 0; i < retryNum; i++ {
-		token, err := this.AccessToken.Fresh()
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, err
-		}
-		resp, err := http.Get(url + token)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, err
-		}
-		defer resp.Body.Close()
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, err
-		}
-		// has error?
-		var rtn response
-		if err := json.Unmarshal(data, &rtn); err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, err
-		}
-		// yes
-		if rtn.ErrCode != 0 {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, errors.New(fmt.Sprintf("%d %s", rtn.ErrCode, rtn.ErrMsg))
-		}
-		// no
-		if err := json.Unmarshal(data, &menu); err != nil {
-			if i < retryNum-1 {
-				continue
-			}
-			return nil, err
-		}
-		break // success
+	if err := this.getAPI("menu/get", nil, &menu); err != nil {
+		return nil, nil, err
 	}
-	return menu.Menu.Button, nil
+	return menu.Menu.Button, menu.ConditionalMenu, nil
 }
 
 // delete custom menu
@@ -621,22 +637,29 @@ func (this *Weixinmp) DeleteCustomMenu() error {
 }
 
 type UserInfo struct {
-	Subscribe     int64  `json:"subscribe"`
-	Openid        string `json:"openid"`
-	Nickname      string `json:"nickname"`
-	Sex           int64  `json:"sex"`
-	Language      string `json:"language"`
-	City          string `json:"city"`
-	Province      string `json:"province"`
-	Country       string `json:"country"`
-	Headimgurl    string `json:"headimgurl"`
-	SubscribeTime int64  `json:"subscribe_time"`
-}
-
-// get user info
-func (this *Weixinmp) GetUserInfo(openId string) (UserInfo, error) {
+	Subscribe      int64   `json:"subscribe"`
+	Openid         string  `json:"openid"`
+	Nickname       string  `json:"nickname"`
+	Sex            int64   `json:"sex"`
+	Language       string  `json:"language"`
+	City           string  `json:"city"`
+	Province       string  `json:"province"`
+	Country        string  `json:"country"`
+	Headimgurl     string  `json:"headimgurl"`
+	SubscribeTime  int64   `json:"subscribe_time"`
+	UnionId        string  `json:"unionid"`
+	Remark         string  `json:"remark"`
+	GroupId        int64   `json:"groupid"`
+	TagIdList      []int64 `json:"tagid_list"`
+	SubscribeScene string  `json:"subscribe_scene"`
+	QrScene        int64   `json:"qr_scene"`
+	QrSceneStr     string  `json:"qr_scene_str"`
+}
+
+// get user info, lang overrides the default language
+func (this *Weixinmp) GetUserInfo(openId string, lang ...Lang) (UserInfo, error) {
 	var uinf UserInfo
-	url := fmt.Sprintf("%suser/info?lang=zh_CN&openid=%s&access_token=", UrlPrefix, openId)
+	url := fmt.Sprintf("%suser/info?lang=%s&openid=%s&access_token=", UrlPrefix, this.lang(lang...), openId)
 	// retry
 	for i := 0; i < retryNum; i++ {
 		token, err := this.AccessToken.Fresh()
@@ -674,7 +697,7 @@ func (this *Weixinmp) GetUserInfo(openId string) (UserInfo, error) {
 			if i < retryNum-1 {
 				continue
 			}
-			return uinf, errors.New(fmt.Sprintf("%d %s", rtn.ErrCode, rtn.ErrMsg))
+			return uinf, &Error{rtn.ErrCode, rtn.ErrMsg}
 		}
 		// no
 		if err := json.Unmarshal(data, &uinf); err != nil {